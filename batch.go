@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+// SpecialBatchIndexKey is added to each spec in batch mode and holds the 1-based position of the spec in the batch.
+const SpecialBatchIndexKey = "_spiro_batch_index_"
+
+// defaultBatchDirTemplate names each batch output directory after the position of its spec.
+const defaultBatchDirTemplate = "{{ ._spiro_batch_index_ }}"
+
+// toSpecMap converts a decoded document into the top level spec map.
+func toSpecMap(in interface{}) (map[string]interface{}, error) {
+	switch v := in.(type) {
+	case map[string]interface{}:
+		return v, nil
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[fmt.Sprint(key)] = item
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("expected a map but got %T", in)
+}
+
+// appendSpecDocument adds a document to the batch. A top level list contributes each of its items.
+func appendSpecDocument(specs []map[string]interface{}, doc interface{}) ([]map[string]interface{}, error) {
+	if doc == nil {
+		return specs, nil
+	}
+	if items, ok := doc.([]interface{}); ok {
+		for i, item := range items {
+			spec, err := toSpecMap(item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d of the top level list: %s", i+1, err)
+			}
+			specs = append(specs, spec)
+		}
+		return specs, nil
+	}
+	spec, err := toSpecMap(doc)
+	if err != nil {
+		return nil, fmt.Errorf("document %d: %s", len(specs)+1, err)
+	}
+	return append(specs, spec), nil
+}
+
+// yamlSpecDocument decodes a document of a multi-document YAML spec file. Maps are decoded with string keys, as they are
+// by decodeSpec, so that keys such as "on" or "yes" are not read as booleans. A top level list contributes each of its
+// items.
+type yamlSpecDocument struct {
+	specs []map[string]interface{}
+}
+
+func (d *yamlSpecDocument) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var spec map[string]interface{}
+	if err := unmarshal(&spec); err == nil {
+		d.specs = append(d.specs, spec)
+		return nil
+	}
+	var list []interface{}
+	if err := unmarshal(&list); err != nil {
+		return fmt.Errorf("expected a map or a list of maps")
+	}
+	return unmarshal(&d.specs)
+}
+
+// decodeSpecBatch parses spec content that contains many specs: each document of a multi-document YAML file, each row
+// of a CSV file, or each item of a top level YAML or JSON list. Other formats produce a batch of one.
+func decodeSpecBatch(content []byte, format string) ([]map[string]interface{}, error) {
+	var specs []map[string]interface{}
+	var err error
+	switch format {
+	case specFormatYAML:
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		for n := 1; ; n++ {
+			var doc yamlSpecDocument
+			if derr := dec.Decode(&doc); derr == io.EOF {
+				break
+			} else if derr != nil {
+				return nil, fmt.Errorf("Could not parse yaml spec file: document %d: %s", n, derr)
+			}
+			specs = append(specs, doc.specs...)
+		}
+	case specFormatJSON:
+		var doc interface{}
+		if err := decodeLenientJSONValue(content, &doc); err != nil {
+			return nil, fmt.Errorf("Could not parse json spec file: %s", err)
+		}
+		if specs, err = appendSpecDocument(specs, normalizeJSONNumbers(doc)); err != nil {
+			return nil, fmt.Errorf("Could not parse json spec file: %s", err)
+		}
+	case specFormatCSV:
+		rows, err := parseCSVRecords(content)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse csv spec file: %s", err)
+		}
+		for _, row := range rows {
+			specs = append(specs, row.(map[string]interface{}))
+		}
+	default:
+		spec, err := decodeSpec(content, format)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("The spec file does not contain any specs to render")
+	}
+	return specs, nil
+}
+
+// renderBatch renders the template once for each spec. Each run writes into its own subdirectory of the output
+// directory, named by rendering the batch directory template against that spec.
+func renderBatch(specs []map[string]interface{}, outputDirectory, dirTemplate string, settings *renderSettings) error {
+	root, err := filepath.Abs(outputDirectory)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]int)
+	for i, spec := range specs {
+		spec[SpecialBatchIndexKey] = i + 1
+		_, err := renderSpec(spec, settings, func(tf *templatefactory.TemplateFactory) (string, error) {
+			name, err := tf.Render(templatefactory.Source{Path: "-batch-dir"}, dirTemplate)
+			if err != nil {
+				return "", fmt.Errorf("Error while rendering the batch directory name for spec %d: %w", i+1, err)
+			}
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return "", fmt.Errorf("The batch directory name for spec %d evaluated to ''", i+1)
+			}
+			target := filepath.Join(root, name)
+			if !isWithin(root, target) || target == root {
+				return "", fmt.Errorf("The batch directory name '%s' for spec %d is outside of the output directory", name, i+1)
+			}
+			if previous, ok := seen[target]; ok {
+				return "", fmt.Errorf("Specs %d and %d both render to the batch directory '%s'", previous, i+1, name)
+			}
+			seen[target] = i + 1
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return "", fmt.Errorf("Could not create batch directory '%s': %s", target, err)
+			}
+			return filepath.Join(outputDirectory, name), nil
+		}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// catalogDirName is the directory inside the spiro configuration directory that is always on the template search path.
+const catalogDirName = "templates"
+
+// catalogArchiveSuffixes are the archive formats that can be used as templates.
+var catalogArchiveSuffixes = []string{".tar.gz", ".tgz", ".tar", ".zip"}
+
+// catalogEntry is a template that can be referred to by name. The source is a directory, an archive (a local path or
+// an http(s) URL) or a git repository written as `git::<url>` or a URL ending in `.git`. Git sources may select a
+// subdirectory with `//<dir>` and a branch or tag with `?ref=<ref>`.
+type catalogEntry struct {
+	Name        string `yaml:"name"`
+	Source      string `yaml:"source"`
+	Description string `yaml:"description"`
+}
+
+// templateSearchPath returns the directories that are searched for templates: the entries of $SPIRO_PATH, the
+// template_path entries of the user config and finally the templates directory of the spiro configuration directory.
+func templateSearchPath(config *userConfig) []string {
+	var dirs []string
+	for _, dir := range filepath.SplitList(os.Getenv("SPIRO_PATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	dirs = append(dirs, config.TemplatePath...)
+	if configDir, err := userConfigDir(); err == nil {
+		dirs = append(dirs, filepath.Join(configDir, catalogDirName))
+	}
+	return dirs
+}
+
+// archiveName returns the name of a template archive without its suffix, or "" when the file is not an archive.
+func archiveName(fileName string) string {
+	for _, suffix := range catalogArchiveSuffixes {
+		if strings.HasSuffix(strings.ToLower(fileName), suffix) && len(fileName) > len(suffix) {
+			return fileName[:len(fileName)-len(suffix)]
+		}
+	}
+	return ""
+}
+
+// loadCatalog lists every template that can be referred to by name. Templates declared in the user config come first,
+// followed by the directories and archives found on the search path. Earlier templates hide later ones of the same name.
+func loadCatalog(config *userConfig) []catalogEntry {
+	var entries []catalogEntry
+	seen := make(map[string]bool)
+	add := func(entry catalogEntry) {
+		if !seen[entry.Name] {
+			seen[entry.Name] = true
+			entries = append(entries, entry)
+		}
+	}
+	for _, entry := range config.Templates {
+		add(entry)
+	}
+	for _, dir := range templateSearchPath(config) {
+		items, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, item := range items {
+			if strings.HasPrefix(item.Name(), ".") {
+				continue
+			}
+			source := filepath.Join(dir, item.Name())
+			if item.IsDir() {
+				add(catalogEntry{Name: item.Name(), Source: source})
+			} else if name := archiveName(item.Name()); name != "" {
+				add(catalogEntry{Name: name, Source: source})
+			}
+		}
+	}
+	return entries
+}
+
+// describe returns the description of a catalog entry, from its manifest when it is not given in the user config.
+// Remote sources are not fetched just to be described.
+func (e catalogEntry) describe() string {
+	if e.Description != "" {
+		return e.Description
+	}
+	if isGitSource(e.Source) || isRemoteSource(e.Source) {
+		return ""
+	}
+	var manifest *templateManifest
+	if archiveName(filepath.Base(e.Source)) != "" {
+		manifest = readArchiveManifest(e.Source)
+	} else {
+		manifest, _ = loadManifest(e.Source)
+	}
+	if manifest == nil {
+		return ""
+	}
+	return manifest.Description
+}
+
+// printCatalog implements `spiro list`.
+func printCatalog(config *userConfig, out io.Writer) error {
+	entries := loadCatalog(config)
+	if len(entries) == 0 {
+		fmt.Fprintf(out, "No templates found. Add templates to one of: %s\n", strings.Join(templateSearchPath(config), ", "))
+		return nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDESCRIPTION\tSOURCE")
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Name, entry.describe(), entry.Source)
+	}
+	return w.Flush()
+}
+
+// resolveCatalogTemplate finds a template by name and returns the path of its directory. Archives and git sources are
+// fetched into a temporary directory named after the template, which is removed by the returned cleanup function.
+func resolveCatalogTemplate(config *userConfig, name string) (string, func(), error) {
+	for _, entry := range loadCatalog(config) {
+		if entry.Name == name {
+			return fetchTemplateSource(entry.Source, name)
+		}
+	}
+	return "", func() {}, &catalogNotFoundError{name: name}
+}
+
+// fetchTemplateSource returns the directory of a template source. Local directories are used in place, archives and git
+// sources are fetched into a temporary directory with the given name, which is removed by the returned cleanup function.
+func fetchTemplateSource(source, name string) (string, func(), error) {
+	noop := func() {}
+	if !isGitSource(source) && archiveName(path.Base(source)) == "" {
+		return source, noop, nil
+	}
+
+	tmp, err := ioutil.TempDir("", "spiro-template")
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() {
+		os.RemoveAll(tmp)
+	}
+	root := filepath.Join(tmp, name)
+	if isGitSource(source) {
+		err = fetchGitTemplate(source, filepath.Join(tmp, ".fetch"), root)
+	} else {
+		err = fetchArchiveTemplate(source, filepath.Join(tmp, ".fetch"), root)
+	}
+	if err != nil {
+		cleanup()
+		return "", noop, fmt.Errorf("Could not fetch template '%s' from '%s': %s", name, source, err)
+	}
+	return root, cleanup, nil
+}
+
+// catalogNotFoundError is returned when no template in the catalog has the requested name.
+type catalogNotFoundError struct {
+	name string
+}
+
+func (e *catalogNotFoundError) Error() string {
+	return fmt.Sprintf("No template named '%s' was found in the catalog, see `spiro list`", e.name)
+}
+
+// resolveInputTemplate resolves the input template argument. A name that does not exist as a path is looked up in the
+// catalog, and fromCatalog forces the lookup. The returned cleanup function removes any fetched template.
+func resolveInputTemplate(config *userConfig, inputTemplate string, fromCatalog bool) (string, func(), error) {
+	if _, err := os.Stat(inputTemplate); !fromCatalog && (err == nil || !os.IsNotExist(err) || strings.ContainsAny(inputTemplate, `/\`)) {
+		return inputTemplate, func() {}, nil
+	}
+	root, cleanup, err := resolveCatalogTemplate(config, inputTemplate)
+	if _, notFound := err.(*catalogNotFoundError); notFound && !fromCatalog {
+		return "", cleanup, fmt.Errorf("Input template '%s' does not exist!", inputTemplate)
+	}
+	return root, cleanup, err
+}
+
+func isRemoteSource(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// splitGitSource splits a git source into the repository URL, the subdirectory and the ref.
+func splitGitSource(source string) (string, string, string) {
+	source = strings.TrimPrefix(source, "git::")
+	ref := ""
+	if i := strings.LastIndex(source, "?ref="); i >= 0 {
+		source, ref = source[:i], source[i+len("?ref="):]
+	}
+	subdir := ""
+	schemeEnd := 0
+	if i := strings.Index(source, "://"); i >= 0 {
+		schemeEnd = i + len("://")
+	}
+	if i := strings.Index(source[schemeEnd:], "//"); i >= 0 {
+		source, subdir = source[:schemeEnd+i], source[schemeEnd+i+2:]
+	}
+	return source, subdir, ref
+}
+
+func isGitSource(source string) bool {
+	if strings.HasPrefix(source, "git::") {
+		return true
+	}
+	repository, _, _ := splitGitSource(source)
+	return strings.HasSuffix(repository, ".git")
+}
+
+// fetchGitTemplate makes a shallow clone of the repository and moves the template directory to root.
+func fetchGitTemplate(source, workDir, root string) error {
+	repository, subdir, ref := splitGitSource(source)
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	cmd := exec.Command("git", append(args, "--", repository, workDir)...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git clone failed: %s", err)
+	}
+	os.RemoveAll(filepath.Join(workDir, ".git"))
+	templateDir := filepath.Join(workDir, filepath.FromSlash(subdir))
+	if !isWithin(workDir, templateDir) {
+		return fmt.Errorf("the subdirectory '%s' is outside of the repository", subdir)
+	}
+	if info, err := os.Stat(templateDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("the repository does not contain the directory '%s'", subdir)
+	}
+	return os.Rename(templateDir, root)
+}
+
+// fetchArchiveTemplate extracts an archive and moves the template directory to root. An archive that contains a single
+// top level directory is treated as that directory.
+func fetchArchiveTemplate(source, workDir, root string) error {
+	var in io.ReadCloser
+	if isRemoteSource(source) {
+		resp, err := http.Get(source)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return fmt.Errorf("download failed with status %s", resp.Status)
+		}
+		in = resp.Body
+	} else {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		in = f
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return err
+	}
+	name := strings.ToLower(path.Base(source))
+	var err error
+	if strings.HasSuffix(name, ".zip") {
+		err = extractZip(in, workDir)
+	} else {
+		err = extractTar(in, workDir, !strings.HasSuffix(name, ".tar"))
+	}
+	if err != nil {
+		return err
+	}
+
+	items, err := ioutil.ReadDir(workDir)
+	if err != nil {
+		return err
+	}
+	if len(items) == 1 && items[0].IsDir() {
+		return os.Rename(filepath.Join(workDir, items[0].Name()), root)
+	}
+	return os.Rename(workDir, root)
+}
+
+// extractPath returns where an archive member is extracted to, refusing members that would escape the directory.
+func extractPath(dir, member string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(member))
+	if !isWithin(dir, target) {
+		return "", fmt.Errorf("the archive member '%s' is outside of the archive", member)
+	}
+	return target, nil
+}
+
+func extractFile(target string, mode os.FileMode, in io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// extractTar extracts the directories and regular files of a tar archive. Links and special files are skipped.
+func extractTar(in io.Reader, dir string, gzipped bool) error {
+	if gzipped {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		in = gz
+	}
+	tr := tar.NewReader(in)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		target, err := extractPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(target, os.FileMode(header.Mode), tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// extractZip extracts the directories and regular files of a zip archive.
+func extractZip(in io.Reader, dir string) error {
+	// zip needs random access so the archive is buffered in memory
+	content, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return err
+	}
+	for _, member := range zr.File {
+		target, err := extractPath(dir, member.Name)
+		if err != nil {
+			return err
+		}
+		mode := member.Mode()
+		if mode.IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			continue
+		}
+		rc, err := member.Open()
+		if err != nil {
+			return err
+		}
+		err = extractFile(target, mode, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readArchiveManifest reads the manifest of a local template archive by extracting it to a temporary directory,
+// returning nil when there is none.
+func readArchiveManifest(source string) *templateManifest {
+	tmp, err := ioutil.TempDir("", "spiro-manifest")
+	if err != nil {
+		return nil
+	}
+	defer os.RemoveAll(tmp)
+	if err := fetchArchiveTemplate(source, filepath.Join(tmp, "x"), filepath.Join(tmp, "root")); err != nil {
+		return nil
+	}
+	content, err := ioutil.ReadFile(filepath.Join(tmp, "root", manifestFileName))
+	if err != nil {
+		return nil
+	}
+	manifest := &templateManifest{}
+	if yaml.Unmarshal(content, manifest) != nil {
+		return nil
+	}
+	return manifest
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// userConfigFile is the name of the per-user configuration file inside the spiro configuration directory.
+const userConfigFile = "config.yaml"
+
+// userConfig is the optional per-user configuration loaded from ~/.config/spiro/config.yaml.
+type userConfig struct {
+	Plugins      []pluginConfig `yaml:"plugins"`
+	Templates    []catalogEntry `yaml:"templates"`
+	TemplatePath []string       `yaml:"template_path"`
+}
+
+// userConfigDir returns the spiro configuration directory, following $XDG_CONFIG_HOME when it is set.
+func userConfigDir() (string, error) {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "spiro"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "spiro"), nil
+}
+
+// loadUserConfig reads the user configuration file. A missing file is not an error and results in an empty config.
+func loadUserConfig() (*userConfig, error) {
+	config := &userConfig{}
+	dir, err := userConfigDir()
+	if err != nil {
+		return config, nil
+	}
+	configPath := filepath.Join(dir, userConfigFile)
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, fmt.Errorf("Could not read user config '%s': %s", configPath, err)
+	}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("Could not parse user config '%s': %s", configPath, err)
+	}
+	// relative template locations are relative to the config file
+	for i, entry := range config.Templates {
+		if !isGitSource(entry.Source) && !isRemoteSource(entry.Source) && entry.Source != "" && !filepath.IsAbs(entry.Source) {
+			config.Templates[i].Source = filepath.Join(dir, entry.Source)
+		}
+	}
+	for i, templateDir := range config.TemplatePath {
+		if !filepath.IsAbs(templateDir) {
+			config.TemplatePath[i] = filepath.Join(dir, templateDir)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// editErrorMarker starts the comment lines that describe a parse error when the editor is reopened. They are removed
+// from the spec before it is parsed again.
+const editErrorMarker = "spiro error: "
+
+// editCommentPrefixes are the line comment prefixes of each spec format. CSV has no comments so parse errors are
+// printed instead.
+var editCommentPrefixes = map[string]string{
+	specFormatYAML: "# ",
+	specFormatJSON: "// ",
+	specFormatTOML: "# ",
+	specFormatEnv:  "# ",
+	specFormatHCL:  "# ",
+}
+
+// editorCommand returns the user's editor command from $VISUAL or $EDITOR, split into its arguments.
+func editorCommand() ([]string, error) {
+	raw := os.Getenv("VISUAL")
+	if strings.TrimSpace(raw) == "" {
+		raw = os.Getenv("EDITOR")
+	}
+	if strings.TrimSpace(raw) == "" {
+		return nil, fmt.Errorf("You specified --edit but no $VISUAL or $EDITOR is available")
+	}
+	args, err := splitCommandLine(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse editor command '%s': %s", raw, err)
+	}
+	return args, nil
+}
+
+// splitCommandLine splits a command line into arguments following the quoting rules of a POSIX shell: single quotes
+// are literal, double quotes allow backslash escapes and a backslash outside of quotes escapes the next character.
+func splitCommandLine(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case c == '\'':
+			inArg = true
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			current.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			inArg = true
+			for i++; ; i++ {
+				if i >= len(line) {
+					return nil, fmt.Errorf("unterminated double quote")
+				}
+				if line[i] == '"' {
+					break
+				}
+				if line[i] == '\\' && i+1 < len(line) && strings.IndexByte("\"\\$`", line[i+1]) >= 0 {
+					i++
+				}
+				current.WriteByte(line[i])
+			}
+		case c == '\\':
+			inArg = true
+			if i+1 < len(line) {
+				i++
+				current.WriteByte(line[i])
+			}
+		default:
+			inArg = true
+			current.WriteByte(c)
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// stripEditErrors removes the error comments added by a previous round of editing.
+func stripEditErrors(content []byte, commentPrefix string) []byte {
+	if commentPrefix == "" {
+		return content
+	}
+	marker := []byte(commentPrefix + editErrorMarker)
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	out := make([]byte, 0, len(content))
+	for _, line := range lines {
+		if !bytes.HasPrefix(line, marker) {
+			out = append(out, line...)
+		}
+	}
+	return out
+}
+
+// withEditErrors prefixes the content with the error as comment lines.
+func withEditErrors(content []byte, commentPrefix string, parseErr error) []byte {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(strings.TrimSpace(parseErr.Error()), "\n") {
+		buf.WriteString(commentPrefix + editErrorMarker + line + "\n")
+	}
+	buf.WriteString(commentPrefix + editErrorMarker + "fix the spec and save, or quit without saving to give up\n")
+	buf.Write(content)
+	return buf.Bytes()
+}
+
+// editSpec opens the spec in the user's editor and returns the edited content. When the saved spec can not be parsed
+// the editor is opened again with the error at the top of the file, until the spec is valid or the user quits without
+// saving.
+func editSpec(specContents []byte, specFormat string, validate func([]byte) error) ([]byte, error) {
+	editor, err := editorCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	// the spec may contain secrets so the temporary copy is private and overwritten before it is removed
+	tf, err := ioutil.TempFile(os.TempDir(), "spiro-*."+specFormat)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to setup temporary file for editting: %s", err)
+	}
+	defer secureRemove(tf.Name())
+	if err := tf.Chmod(0600); err != nil {
+		tf.Close()
+		return nil, fmt.Errorf("Unable to restrict permissions of temporary file: %s", err)
+	}
+	if err := tf.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to write temporary file: %s", err)
+	}
+
+	commentPrefix := editCommentPrefixes[specFormat]
+	content := specContents
+	for round := 0; ; round++ {
+		if err := ioutil.WriteFile(tf.Name(), content, 0600); err != nil {
+			return nil, fmt.Errorf("Failed to write bytes to temporary file: %s", err)
+		}
+		before, err := os.Stat(tf.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		cmd := exec.Command(editor[0], append(editor[1:], tf.Name())...)
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		cmd.Env = os.Environ()
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("Editor command failed: %s", err)
+		}
+
+		after, err := os.Stat(tf.Name())
+		if err != nil {
+			return nil, err
+		}
+		edited, err := ioutil.ReadFile(tf.Name())
+		if err != nil {
+			return nil, err
+		}
+		if before.ModTime() == after.ModTime() && bytes.Equal(edited, content) {
+			if round == 0 {
+				return nil, fmt.Errorf("No save detected, you must save the file when using -edit")
+			}
+			return nil, fmt.Errorf("Gave up editing the spec, it is still invalid")
+		}
+
+		edited = stripEditErrors(edited, commentPrefix)
+		parseErr := validate(edited)
+		if parseErr == nil {
+			return edited, nil
+		}
+		if commentPrefix == "" {
+			logger.Warn("%s", parseErr)
+			content = edited
+		} else {
+			content = withEditErrors(edited, commentPrefix, parseErr)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxExtendsDepth stops chains of base templates from growing without bound, which can happen when remote bases extend
+// each other through different sources since each source is fetched into a new directory.
+const maxExtendsDepth = 16
+
+// templateLayer is one of the template directories that are overlaid to make up a template. Bases come before the
+// templates that extend them and the input template is the last layer, so later layers override earlier ones.
+type templateLayer struct {
+	root     string
+	manifest *templateManifest
+	// fetched is set for bases that were fetched from a git, remote or archive source, which do not change while spiro
+	// runs.
+	fetched bool
+}
+
+// fetchedBases keeps the base templates fetched from git, remote and archive sources until spiro exits, so that watch
+// mode fetches each source once rather than on every render.
+type fetchedBases struct {
+	dirs     map[string]string
+	cleanups []func()
+}
+
+func newFetchedBases() *fetchedBases {
+	return &fetchedBases{dirs: make(map[string]string)}
+}
+
+// fetch returns the directory of a base template, fetching the source the first time it is seen. Local directories are
+// returned as they are.
+func (f *fetchedBases) fetch(source string) (string, error) {
+	if dir, ok := f.dirs[source]; ok {
+		return dir, nil
+	}
+	dir, cleanup, err := fetchTemplateSource(source, baseName(source))
+	f.cleanups = append(f.cleanups, cleanup)
+	if err != nil {
+		return "", err
+	}
+	f.dirs[source] = dir
+	return dir, nil
+}
+
+// cleanup removes the fetched base templates.
+func (f *fetchedBases) cleanup() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+	f.cleanups = nil
+	f.dirs = make(map[string]string)
+}
+
+// layerClaim records which layer produced an output file, so that earlier layers do not overwrite it.
+type layerClaim struct {
+	source string
+	layer  int
+}
+
+// loadTemplateLayers loads the manifest of a template directory and, in order, each base template that it extends
+// directly or through other bases. Bases that are reached more than once are only included the first time. In safe mode
+// only bases inside the input template are allowed. Bases that are not local directories are fetched through bases.
+func loadTemplateLayers(inputTemplate string, safe *safeLimits, bases *fetchedBases) ([]templateLayer, error) {
+	var layers []templateLayer
+	included := make(map[string]bool)
+
+	var visit func(root string, fetched bool, chain []string) error
+	visit = func(root string, fetched bool, chain []string) error {
+		abs, err := filepath.Abs(root)
+		if err != nil {
+			return err
+		}
+		for _, previous := range chain {
+			if previous == abs {
+				return fmt.Errorf("Template '%s' extends itself: %s -> %s", root, strings.Join(chain, " -> "), abs)
+			}
+		}
+		if included[abs] {
+			return nil
+		}
+		if len(chain) > maxExtendsDepth {
+			return fmt.Errorf("Template '%s' extends base templates more than %d levels deep", inputTemplate, maxExtendsDepth)
+		}
+		manifest, err := loadManifest(root)
+		if err != nil {
+			return err
+		}
+		if err := checkManifestVersion(manifest, root); err != nil {
+			return err
+		}
+		for _, pattern := range manifest.Delete {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("Invalid delete pattern '%s' in template manifest '%s': %s", pattern, filepath.Join(root, manifestFileName), err)
+			}
+		}
+		chain = append(chain, abs)
+		for _, source := range manifest.Extends {
+			if safe != nil {
+				if err := safe.checkBase(inputTemplate, root, source); err != nil {
+					return err
+				}
+			}
+			local := baseSource(root, source)
+			base, err := bases.fetch(local)
+			if err != nil {
+				return fmt.Errorf("Could not load the base template '%s' of '%s': %s", source, root, err)
+			}
+			if info, err := os.Stat(base); err != nil || !info.IsDir() {
+				return fmt.Errorf("The base template '%s' of '%s' must be a template directory", source, root)
+			}
+			if err := visit(base, fetched || base != local, chain); err != nil {
+				return err
+			}
+		}
+		included[abs] = true
+		layers = append(layers, templateLayer{root: root, manifest: manifest, fetched: fetched})
+		return nil
+	}
+	if err := visit(inputTemplate, false, nil); err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
+
+// baseSource resolves a local base template relative to the template that extends it. Git and remote sources are used
+// as they are.
+func baseSource(root, source string) string {
+	if isGitSource(source) || isRemoteSource(source) || filepath.IsAbs(source) {
+		return source
+	}
+	return filepath.Join(root, filepath.FromSlash(source))
+}
+
+// baseName names the directory that a fetched base template is placed in.
+func baseName(source string) string {
+	if isGitSource(source) {
+		repository, subdir, _ := splitGitSource(source)
+		if subdir != "" {
+			return path.Base(subdir)
+		}
+		return strings.TrimSuffix(path.Base(repository), ".git")
+	}
+	if name := archiveName(path.Base(source)); name != "" {
+		return name
+	}
+	return path.Base(filepath.ToSlash(source))
+}
+
+// mergeManifests combines the manifests of the layers in order. Vars of later layers replace those of earlier layers
+// with the same name, and the description and settings come from the last layer that sets them.
+func mergeManifests(layers []templateLayer) *templateManifest {
+	merged := &templateManifest{}
+	for _, layer := range layers {
+		m := layer.manifest
+		merged.Vars = append(merged.Vars, m.Vars...)
+		if m.Description != "" {
+			merged.Description = m.Description
+		}
+		if m.PruneEmptyDirs != nil {
+			merged.PruneEmptyDirs = m.PruneEmptyDirs
+		}
+		merged.found = merged.found || m.found
+	}
+	return merged
+}
+
+// loadLayers loads the input template directory and the bases it extends into the settings. Loading the layers again
+// reuses the bases that were fetched the first time. The cleanup function returned by the first load removes the
+// fetched bases, later loads return a function that does nothing.
+func (s *renderSettings) loadLayers() (func(), error) {
+	cleanup := func() {}
+	if s.bases == nil {
+		s.bases = newFetchedBases()
+		cleanup = s.bases.cleanup
+	}
+	layers, err := loadTemplateLayers(s.inputTemplate, s.safe, s.bases)
+	if err != nil {
+		return cleanup, err
+	}
+	s.layers = layers
+	s.manifest = mergeManifests(layers)
+	return cleanup, nil
+}
+
+// localRoots returns the roots of the layers that are local directories and so may change while spiro runs.
+func localRoots(inputTemplate string, layers []templateLayer) []string {
+	if len(layers) == 0 {
+		return []string{inputTemplate}
+	}
+	var roots []string
+	for _, layer := range layers {
+		if !layer.fetched {
+			roots = append(roots, layer.root)
+		}
+	}
+	return roots
+}
+
+// layerRoots returns the roots of the layers in the order that file functions search them: the input template first,
+// then its bases from the last to the first.
+func layerRoots(root string, layers []templateLayer) []string {
+	if len(layers) == 0 {
+		return []string{root}
+	}
+	roots := make([]string, 0, len(layers))
+	for i := len(layers) - 1; i >= 0; i-- {
+		roots = append(roots, layers[i].root)
+	}
+	return roots
+}
+
+// processBases overlays the base templates onto the output of the input template, last base first. Their files are
+// only written where no later layer has produced a file at the same path.
+func (p *processor) processBases(outputDir string) error {
+	for i := len(p.layers) - 2; i >= 0; i-- {
+		p.layer = i
+		if err := p.processItems(p.layers[i].root, outputDir); err != nil {
+			return err
+		}
+	}
+	p.layer = len(p.layers) - 1
+	return nil
+}
+
+// overlaid reports whether an output of a base template is replaced or deleted by a later layer, and logs why it is
+// skipped.
+func (p *processor) overlaid(templateString, outputPath string, dir bool) bool {
+	if p.layer >= len(p.layers)-1 {
+		return false
+	}
+	rel := outputPath
+	if p.layerOutput != "." {
+		rel = strings.TrimPrefix(outputPath, p.layerOutput+"/")
+	}
+	for j := p.layer + 1; j < len(p.layers); j++ {
+		for _, pattern := range p.layers[j].manifest.Delete {
+			pattern = strings.TrimSuffix(pattern, "/")
+			if matched, _ := path.Match(pattern, rel); matched || strings.HasPrefix(rel, pattern+"/") {
+				logger.Skip(templateString, fmt.Sprintf("it is deleted by '%s'", filepath.Join(p.layers[j].root, manifestFileName)))
+				return true
+			}
+		}
+	}
+	if claim, ok := p.claimed[outputPath]; ok && !dir && claim.layer > p.layer {
+		logger.Skip(templateString, fmt.Sprintf("it is overridden by '%s'", claim.source))
+		return true
+	}
+	return false
+}
+
+// claim records that the current layer produced an output file.
+func (p *processor) claim(templateString, outputPath string) {
+	if len(p.layers) > 1 {
+		p.claimed[outputPath] = layerClaim{source: templateString, layer: p.layer}
+	}
+}
+
+// isLayerRoot reports whether the directory is the root of the input template or one of its bases.
+func (p *processor) isLayerRoot(dir string) bool {
+	for _, root := range layerRoots(p.root, p.layers) {
+		if path.Clean(dir) == path.Clean(root) {
+			return true
+		}
+	}
+	return false
+}
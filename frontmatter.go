@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+// frontMatterOpen starts the optional front matter block on the first line of a .templated file. The block is YAML and
+// ends at a line containing only frontMatterClose.
+const (
+	frontMatterOpen  = "---spiro"
+	frontMatterClose = "---"
+)
+
+// frontMatter controls how a single templated file is rendered.
+type frontMatter struct {
+	// Path replaces the rendered file name. It may contain templating and slashes and is relative to the directory of
+	// the file.
+	Path string `yaml:"path"`
+	// Mode replaces the permission bits copied from the template file, as an octal string such as "0755".
+	Mode interface{} `yaml:"mode"`
+	// If is a template expression, the file is skipped when it is not true.
+	If string `yaml:"if"`
+	// SkipIfEmpty skips the file when the rendered body contains only whitespace.
+	SkipIfEmpty bool `yaml:"skip_if_empty"`
+	// Inject inserts the rendered body into the existing file at the output path instead of writing the file.
+	Inject *injectRule `yaml:"inject"`
+
+	// pathStart, ifStart and bodyStart are the positions of the templated parts within the file, for error messages.
+	pathStart, ifStart, bodyStart position
+}
+
+// position is a 1-based line and column in a file.
+type position struct {
+	line, column int
+}
+
+// parseFrontMatter splits the front matter from the body of a templated file. Files without front matter return a nil
+// front matter and their whole content as the body.
+func parseFrontMatter(content []byte) (*frontMatter, []byte, error) {
+	firstLine, rest := splitFirstLine(content)
+	if string(bytes.TrimRight(firstLine, "\r\n")) != frontMatterOpen {
+		return nil, content, nil
+	}
+	var block []byte
+	var lines []string
+	for len(rest) > 0 {
+		var line []byte
+		line, rest = splitFirstLine(rest)
+		lines = append(lines, string(line))
+		if string(bytes.TrimRight(line, "\r\n")) == frontMatterClose {
+			fm := &frontMatter{}
+			if err := yaml.UnmarshalStrict(block, fm); err != nil {
+				return nil, nil, fmt.Errorf("invalid front matter: %s", err)
+			}
+			if fm.Inject != nil {
+				if err := fm.Inject.validate(); err != nil {
+					return nil, nil, fmt.Errorf("invalid front matter injection: %s", err)
+				}
+			}
+			fm.pathStart = findFrontMatterValue(lines, "path", fm.Path)
+			fm.ifStart = findFrontMatterValue(lines, "if", fm.If)
+			fm.bodyStart = position{line: len(lines) + 2, column: 1}
+			return fm, rest, nil
+		}
+		block = append(block, line...)
+	}
+	return nil, nil, fmt.Errorf("the front matter is not closed by a '%s' line", frontMatterClose)
+}
+
+// findFrontMatterValue returns the position of a top level value in the lines of the front matter block, which start
+// on the second line of the file. Values that are quoted or span several lines only have their key's line found.
+func findFrontMatterValue(lines []string, key, value string) position {
+	for i, line := range lines {
+		if !strings.HasPrefix(line, key+":") {
+			continue
+		}
+		found := position{line: i + 2}
+		if value != "" {
+			if column := strings.Index(line[len(key)+1:], value); column >= 0 {
+				found.column = len(key) + 1 + column + 1
+			}
+		}
+		return found
+	}
+	return position{}
+}
+
+func splitFirstLine(content []byte) ([]byte, []byte) {
+	if i := bytes.IndexByte(content, '\n'); i >= 0 {
+		return content[:i+1], content[i+1:]
+	}
+	return content, nil
+}
+
+// fileMode returns the mode set by the front matter, if any. YAML integers written with a leading zero are already
+// octal, strings are parsed as octal.
+func (f *frontMatter) fileMode() (os.FileMode, bool, error) {
+	var mode uint64
+	switch v := f.Mode.(type) {
+	case nil:
+		return 0, false, nil
+	case int:
+		mode = uint64(v)
+	case string:
+		parsed, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid front matter mode '%s', expected an octal number like \"0644\"", v)
+		}
+		mode = parsed
+	default:
+		return 0, false, fmt.Errorf("invalid front matter mode '%v', expected an octal number like \"0644\"", v)
+	}
+	if mode > 0777 {
+		return 0, false, fmt.Errorf("invalid front matter mode %#o, only permission bits can be set", mode)
+	}
+	return os.FileMode(mode), true, nil
+}
+
+// source describes a template that starts at this position of the file.
+func (pos position) source(templateString, kind string) templatefactory.Source {
+	return templatefactory.Source{Path: templateString, Kind: kind, Line: pos.line, Column: pos.column}
+}
+
+// condition evaluates the `if` expression of the front matter, files without one are always rendered.
+func (f *frontMatter) condition(p *processor, templateString string) (bool, error) {
+	if f.If == "" {
+		return true, nil
+	}
+	value, err := p.tf.Evaluate(f.ifStart.source(templateString, templateKindCondition), f.If)
+	if err != nil {
+		return false, err
+	}
+	truth, _ := template.IsTrue(value)
+	return truth, nil
+}
+
+// referenceText returns the templated parts of the file, the path, condition and body, so that watch mode can tell
+// which spec values the file depends on.
+func (f *frontMatter) referenceText(tf *templatefactory.TemplateFactory, body []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(f.Path)
+	if f.If != "" {
+		startDelim, endDelim := tf.Delimiters()
+		buf.WriteString(startDelim + " " + f.If + " " + endDelim)
+	}
+	buf.Write(body)
+	return buf.Bytes()
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// hclParser parses a small subset of HCL: attributes (`name = "x"`), blocks with optional labels
+// (`service "web" { port = 80 }`), strings, numbers, booleans, null, lists and objects. Expressions, interpolation and
+// heredocs are not supported.
+//
+// A labelled block becomes a nested map keyed by each label in turn. Repeating an unlabelled block produces a list of
+// maps.
+type hclParser struct {
+	src  []rune
+	pos  int
+	line int
+}
+
+// decodeHCL parses an HCL-lite document into a map.
+func decodeHCL(content []byte) (map[string]interface{}, error) {
+	p := &hclParser{src: []rune(string(content)), line: 1}
+	body, err := p.parseBody(false)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %s", p.line, err)
+	}
+	return body, nil
+}
+
+func (p *hclParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *hclParser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *hclParser) next() rune {
+	r := p.src[p.pos]
+	p.pos++
+	if r == '\n' {
+		p.line++
+	}
+	return r
+}
+
+// skip skips whitespace and comments. Newlines are only skipped when requested since they separate attributes.
+func (p *hclParser) skip(newlines bool) {
+	for !p.eof() {
+		r := p.peek()
+		switch {
+		case r == ' ' || r == '\t' || r == '\r' || (newlines && r == '\n'):
+			p.next()
+		case r == '#' || (r == '/' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '/'):
+			for !p.eof() && p.peek() != '\n' {
+				p.next()
+			}
+		case r == '/' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '*':
+			p.pos += 2
+			for !p.eof() && !(p.peek() == '*' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '/') {
+				p.next()
+			}
+			p.pos += 2
+		default:
+			return
+		}
+	}
+}
+
+func isHCLIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
+
+func (p *hclParser) parseIdent() (string, error) {
+	start := p.pos
+	for !p.eof() && isHCLIdentRune(p.peek()) {
+		p.next()
+	}
+	if start == p.pos {
+		if p.eof() {
+			return "", fmt.Errorf("unexpected end of document")
+		}
+		return "", fmt.Errorf("unexpected '%c'", p.peek())
+	}
+	return string(p.src[start:p.pos]), nil
+}
+
+func (p *hclParser) parseString() (string, error) {
+	p.next()
+	var buf strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		r := p.next()
+		switch r {
+		case '"':
+			return buf.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", fmt.Errorf("unterminated string")
+			}
+			switch e := p.next(); e {
+			case 'n':
+				buf.WriteRune('\n')
+			case 't':
+				buf.WriteRune('\t')
+			case 'r':
+				buf.WriteRune('\r')
+			case '"', '\\':
+				buf.WriteRune(e)
+			default:
+				return "", fmt.Errorf("invalid escape sequence '\\%c'", e)
+			}
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+// parseBody parses attributes and blocks until the end of the document or, for nested bodies, a closing brace.
+func (p *hclParser) parseBody(nested bool) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+	repeated := make(map[string]bool)
+	for {
+		p.skip(true)
+		if p.eof() {
+			if nested {
+				return nil, fmt.Errorf("unterminated block")
+			}
+			return out, nil
+		}
+		if p.peek() == '}' && nested {
+			p.next()
+			return out, nil
+		}
+		name, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		p.skip(false)
+
+		if p.peek() == '=' || p.peek() == ':' {
+			p.next()
+			p.skip(false)
+			if _, exists := out[name]; exists {
+				return nil, fmt.Errorf("'%s' is defined more than once", name)
+			}
+			if out[name], err = p.parseValue(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var labels []string
+		for p.peek() == '"' || (isHCLIdentRune(p.peek()) && p.peek() != '{') {
+			var label string
+			if p.peek() == '"' {
+				label, err = p.parseString()
+			} else {
+				label, err = p.parseIdent()
+			}
+			if err != nil {
+				return nil, err
+			}
+			labels = append(labels, label)
+			p.skip(false)
+		}
+		if p.eof() || p.next() != '{' {
+			return nil, fmt.Errorf("expected '=' or a block after '%s'", name)
+		}
+		body, err := p.parseBody(true)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.assignBlock(out, repeated, name, labels, body); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *hclParser) assignBlock(out map[string]interface{}, repeated map[string]bool, name string, labels []string, body map[string]interface{}) error {
+	if len(labels) == 0 {
+		switch existing := out[name].(type) {
+		case nil:
+			out[name] = body
+		case map[string]interface{}:
+			if !repeated[name] {
+				out[name] = []interface{}{existing, body}
+				repeated[name] = true
+				return nil
+			}
+			return fmt.Errorf("block '%s' conflicts with an existing value", name)
+		case []interface{}:
+			if !repeated[name] {
+				return fmt.Errorf("block '%s' conflicts with an existing value", name)
+			}
+			out[name] = append(existing, body)
+		default:
+			return fmt.Errorf("block '%s' conflicts with an existing value", name)
+		}
+		return nil
+	}
+
+	path := append([]string{name}, labels...)
+	current := out
+	for _, key := range path[:len(path)-1] {
+		switch existing := current[key].(type) {
+		case nil:
+			child := make(map[string]interface{})
+			current[key] = child
+			current = child
+		case map[string]interface{}:
+			current = existing
+		default:
+			return fmt.Errorf("block '%s' conflicts with an existing value", strings.Join(path, "."))
+		}
+	}
+	last := path[len(path)-1]
+	if _, exists := current[last]; exists {
+		return fmt.Errorf("block '%s' is defined more than once", strings.Join(path, "."))
+	}
+	current[last] = body
+	return nil
+}
+
+func (p *hclParser) parseValue() (interface{}, error) {
+	switch r := p.peek(); {
+	case r == '"':
+		return p.parseString()
+	case r == '[':
+		return p.parseList()
+	case r == '{':
+		p.next()
+		return p.parseObject()
+	case r == '-' || r == '+' || unicode.IsDigit(r):
+		return p.parseNumber()
+	case isHCLIdentRune(r):
+		word, _ := p.parseIdent()
+		switch word {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unsupported value '%s', expressions are not supported", word)
+	case r == 0:
+		return nil, fmt.Errorf("expected a value but reached the end of the document")
+	}
+	return nil, fmt.Errorf("unexpected '%c'", p.peek())
+}
+
+func (p *hclParser) parseNumber() (interface{}, error) {
+	start := p.pos
+	for !p.eof() && strings.ContainsRune("+-0123456789.eE_", p.peek()) {
+		p.next()
+	}
+	raw := string(p.src[start:p.pos])
+	if i, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return int(i), nil
+	}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil {
+		return f, nil
+	}
+	return nil, fmt.Errorf("invalid number '%s'", raw)
+}
+
+func (p *hclParser) parseList() ([]interface{}, error) {
+	p.next()
+	out := []interface{}{}
+	for {
+		p.skip(true)
+		if p.eof() {
+			return nil, fmt.Errorf("unterminated list")
+		}
+		if p.peek() == ']' {
+			p.next()
+			return out, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+		p.skip(true)
+		if p.peek() == ',' {
+			p.next()
+		} else if p.peek() != ']' {
+			return nil, fmt.Errorf("expected ',' or ']' in list")
+		}
+	}
+}
+
+func (p *hclParser) parseObject() (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+	for {
+		p.skip(true)
+		if p.eof() {
+			return nil, fmt.Errorf("unterminated object")
+		}
+		if p.peek() == '}' {
+			p.next()
+			return out, nil
+		}
+		var key string
+		var err error
+		if p.peek() == '"' {
+			key, err = p.parseString()
+		} else {
+			key, err = p.parseIdent()
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.skip(false)
+		if p.eof() || (p.peek() != '=' && p.peek() != ':') {
+			return nil, fmt.Errorf("expected '=' after object key '%s'", key)
+		}
+		p.next()
+		p.skip(false)
+		if out[key], err = p.parseValue(); err != nil {
+			return nil, err
+		}
+		p.skip(false)
+		if p.peek() == ',' {
+			p.next()
+		}
+	}
+}
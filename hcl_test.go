@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeHCL(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  map[string]interface{}
+	}{
+		{"empty", "", map[string]interface{}{}},
+		{"attributes", "name = \"web\"\nport = 8080\nratio = 0.5\nenabled = true\nmissing = null\n", map[string]interface{}{
+			"name": "web", "port": 8080, "ratio": 0.5, "enabled": true, "missing": nil,
+		}},
+		{"colon assignment", "name: \"web\"\n", map[string]interface{}{"name": "web"}},
+		{"comments", "# hash\n// slashes\n/* block\ncomment */ a = 1 // trailing\n", map[string]interface{}{"a": 1}},
+		{"escapes", `a = "line\nnext \"quoted\" \\"` + "\n", map[string]interface{}{"a": "line\nnext \"quoted\" \\"}},
+		{"negative numbers", "a = -3\nb = -1.5e3\n", map[string]interface{}{"a": -3, "b": -1500.0}},
+		{"lists", "a = [1, \"two\", [3]]\nb = [\n  \"x\",\n  \"y\",\n]\nc = []\n", map[string]interface{}{
+			"a": []interface{}{1, "two", []interface{}{3}},
+			"b": []interface{}{"x", "y"},
+			"c": []interface{}{},
+		}},
+		{"objects", "a = { x = 1, \"y z\" = 2 }\nb = {\n  c: true\n}\n", map[string]interface{}{
+			"a": map[string]interface{}{"x": 1, "y z": 2},
+			"b": map[string]interface{}{"c": true},
+		}},
+		{"block", "server {\n  host = \"x\"\n}\n", map[string]interface{}{
+			"server": map[string]interface{}{"host": "x"},
+		}},
+		{"labelled blocks", "service \"web\" {\n  port = 80\n}\nservice \"db\" {\n  port = 5432\n}\n", map[string]interface{}{
+			"service": map[string]interface{}{
+				"web": map[string]interface{}{"port": 80},
+				"db":  map[string]interface{}{"port": 5432},
+			},
+		}},
+		{"multiple labels", "resource aws_instance \"main\" {\n  size = \"small\"\n}\n", map[string]interface{}{
+			"resource": map[string]interface{}{
+				"aws_instance": map[string]interface{}{"main": map[string]interface{}{"size": "small"}},
+			},
+		}},
+		{"repeated unlabelled blocks", "rule {\n  a = 1\n}\nrule {\n  a = 2\n}\nrule {\n  a = 3\n}\n", map[string]interface{}{
+			"rule": []interface{}{
+				map[string]interface{}{"a": 1},
+				map[string]interface{}{"a": 2},
+				map[string]interface{}{"a": 3},
+			},
+		}},
+		{"nested blocks", "outer {\n  inner \"x\" {\n    v = 1\n  }\n}\n", map[string]interface{}{
+			"outer": map[string]interface{}{"inner": map[string]interface{}{"x": map[string]interface{}{"v": 1}}},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeHCL([]byte(c.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeHCLErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"duplicate attribute", "a = 1\na = 2\n", "line 2: 'a' is defined more than once"},
+		{"duplicate labelled block", "s \"x\" {\n}\ns \"x\" {\n}\n", "block 's.x' is defined more than once"},
+		{"block conflicts with attribute", "a = 1\na {\n}\n", "block 'a' conflicts with an existing value"},
+		{"labelled block conflicts with attribute", "a = 1\na \"x\" {\n}\n", "block 'a.x' conflicts with an existing value"},
+		{"expression", "a = var.x\n", "expressions are not supported"},
+		{"missing value", "a =", "expected a value but reached the end of the document"},
+		{"unterminated string", "a = \"x\n", "unterminated string"},
+		{"invalid escape", `a = "\q"`, "invalid escape sequence"},
+		{"unterminated block", "a {\n  b = 1\n", "unterminated block"},
+		{"unterminated list", "a = [1,\n", "unterminated list"},
+		{"unterminated object", "a = {\n", "unterminated object"},
+		{"missing equals", "a \"x\"\n", "expected '=' or a block after 'a'"},
+		{"invalid number", "a = 1.2.3\n", "invalid number '1.2.3'"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := decodeHCL([]byte(c.input))
+			if err == nil {
+				t.Fatalf("expected an error containing %q", c.want)
+			}
+			if !strings.Contains(err.Error(), c.want) {
+				t.Errorf("got error %q, want it to contain %q", err, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// fileFunctions are the template functions that read other files from the template tree. Templates that use them are
+// rendered again whenever anything in the tree or the spec changes.
+var fileFunctions = []string{"readFile", "includeTemplate", "data"}
+
+// renderedOutput is a file or directory written by a render.
+type renderedOutput struct {
+	source      string
+	fingerprint string
+	dir         bool
+}
+
+// renderState records the outputs of a render, keyed by output path, so that the next render can skip files whose
+// inputs have not changed and remove outputs whose source has gone.
+type renderState struct {
+	outputs map[string]renderedOutput
+}
+
+func newRenderState() *renderState {
+	return &renderState{outputs: make(map[string]renderedOutput)}
+}
+
+// record adds an output to the current render state, when the processor is tracking one.
+func (p *processor) record(outputPath, source, fingerprint string, dir bool) {
+	if p.current != nil {
+		p.current.outputs[outputPath] = renderedOutput{source: source, fingerprint: fingerprint, dir: dir}
+	}
+}
+
+// unchanged reports whether the output was produced by the previous render from the same inputs and still exists.
+func (p *processor) unchanged(outputPath, fingerprint string) bool {
+	if p.previous == nil || fingerprint == "" {
+		return false
+	}
+	previous, ok := p.previous.outputs[outputPath]
+	if !ok || previous.fingerprint != fingerprint {
+		return false
+	}
+	return p.out.Exists(outputPath)
+}
+
+// fingerprint summarises the inputs of an output file: the source file, the output path and, for templated files, the
+// values of the spec fields that the template refers to. An empty fingerprint means the file must always be processed.
+func (p *processor) fingerprint(source string, info os.FileInfo, outputPath string, content []byte, templated bool) string {
+	if p.current == nil {
+		return ""
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00%d\x00", source, outputPath, info.Mode(), info.Size(), info.ModTime().UnixNano())
+	if templated {
+		startDelim, endDelim := p.tf.Delimiters()
+		fmt.Fprintf(h, "%s\x00%s\x00", startDelim, endDelim)
+		refs, err := findTemplateReferences(string(content), startDelim, endDelim)
+		if err != nil {
+			// the render reports the error
+			return ""
+		}
+		usesFiles := false
+		for _, name := range fileFunctions {
+			usesFiles = usesFiles || refs.functions[name]
+		}
+		// files read through the file functions may refer to any field of the spec
+		if refs.wholeSpec || usesFiles {
+			writeFingerprintValue(h, *p.spec)
+		} else {
+			fields := make([]string, 0, len(refs.fields))
+			for field := range refs.fields {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				value, ok := (*p.spec)[field]
+				fmt.Fprintf(h, "%s\x00%t\x00", field, ok)
+				writeFingerprintValue(h, value)
+			}
+		}
+		if usesFiles {
+			if p.treeStamp == "" {
+				stamp, err := stampTree(p.root)
+				if err != nil {
+					return ""
+				}
+				p.treeStamp = stamp
+			}
+			fmt.Fprintf(h, "%s\x00", p.treeStamp)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeFingerprintValue(h hash.Hash, value interface{}) {
+	if raw, err := json.Marshal(normalize(value)); err == nil {
+		h.Write(raw)
+	} else {
+		fmt.Fprintf(h, "%#v", value)
+	}
+	h.Write([]byte{0})
+}
+
+// stampTree summarises the names, sizes, modes and modification times of every file under the root, so that any change
+// in the tree changes the stamp.
+func stampTree(root string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d\x00", path, info.Mode(), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// removeStaleOutputs removes the outputs of the previous render that the current render did not produce, because their
+// source was removed or now renders to a different path. Directories are only removed once they are empty.
+func removeStaleOutputs(previous, current *renderState) {
+	var stale []string
+	for outputPath := range previous.outputs {
+		if _, ok := current.outputs[outputPath]; !ok {
+			stale = append(stale, outputPath)
+		}
+	}
+	// remove the deepest paths first so that directories are emptied before they are removed
+	sort.Sort(sort.Reverse(sort.StringSlice(stale)))
+	for _, outputPath := range stale {
+		output := previous.outputs[outputPath]
+		if err := os.Remove(outputPath); err != nil {
+			// directories that still contain files are left alone
+			if !output.dir && !os.IsNotExist(err) {
+				logger.Warn("Could not remove '%s': %s", outputPath, err)
+			}
+			continue
+		}
+		logger.Remove(output.source, outputPath)
+	}
+}
+
+// merge returns the outputs of the previous render updated with those of an incomplete current render.
+func (s *renderState) merge(current *renderState) *renderState {
+	merged := newRenderState()
+	for outputPath, output := range s.outputs {
+		merged.outputs[outputPath] = output
+	}
+	for outputPath, output := range current.outputs {
+		merged.outputs[outputPath] = output
+	}
+	return merged
+}
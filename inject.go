@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+// injectRule inserts the rendered content of a file into an existing output file instead of writing a file of its own.
+// Exactly one position must be given. Injection is idempotent: content that is already in place is not inserted again.
+type injectRule struct {
+	// Before and After insert the content before or after the first line that matches a regular expression.
+	Before string `yaml:"before"`
+	After  string `yaml:"after"`
+	// BeforeMarker and AfterMarker insert the content before or after the first line that contains the marker text,
+	// usually a comment such as "// spiro:routes".
+	BeforeMarker string `yaml:"before_marker"`
+	AfterMarker  string `yaml:"after_marker"`
+	// Between replaces the lines between the first line containing the first marker and the next line containing the
+	// second marker.
+	Between []string `yaml:"between"`
+}
+
+// manifestInjection declares an injection in the template manifest for a file that has no front matter of its own.
+type manifestInjection struct {
+	// File is the template file whose content is injected, relative to the template root.
+	File string `yaml:"file"`
+	// Into is the file to inject into, relative to the output of the template root. It may contain templating and
+	// defaults to the path that the file would otherwise be rendered to.
+	Into string `yaml:"into"`
+
+	injectRule `yaml:",inline"`
+}
+
+// validate checks that the rule has exactly one position and that its patterns compile.
+func (r *injectRule) validate() error {
+	given := 0
+	for _, position := range []string{r.Before, r.After, r.BeforeMarker, r.AfterMarker} {
+		if position != "" {
+			given++
+		}
+	}
+	if r.Between != nil {
+		given++
+		if len(r.Between) != 2 || r.Between[0] == "" || r.Between[1] == "" {
+			return fmt.Errorf("between must be a list of two markers")
+		}
+	}
+	if given != 1 {
+		return fmt.Errorf("exactly one of before, after, before_marker, after_marker or between must be given")
+	}
+	for _, pattern := range []string{r.Before, r.After} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// describe names the position for log and error messages.
+func (r *injectRule) describe() string {
+	switch {
+	case r.Before != "":
+		return fmt.Sprintf("the line matching '%s'", r.Before)
+	case r.After != "":
+		return fmt.Sprintf("the line matching '%s'", r.After)
+	case r.BeforeMarker != "":
+		return fmt.Sprintf("the line containing '%s'", r.BeforeMarker)
+	case r.AfterMarker != "":
+		return fmt.Sprintf("the line containing '%s'", r.AfterMarker)
+	}
+	return fmt.Sprintf("the lines containing '%s' and '%s'", r.Between[0], r.Between[1])
+}
+
+// apply injects the snippet into the content. It returns false when the snippet is already in place.
+func (r *injectRule) apply(content, snippet string) (string, bool, error) {
+	if snippet != "" && !strings.HasSuffix(snippet, "\n") {
+		snippet += "\n"
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if r.Between != nil {
+		start := findLine(lines, 0, func(line string) bool { return strings.Contains(line, r.Between[0]) })
+		if start < 0 {
+			return "", false, fmt.Errorf("no line contains the start marker '%s'", r.Between[0])
+		}
+		end := findLine(lines, start+1, func(line string) bool { return strings.Contains(line, r.Between[1]) })
+		if end < 0 {
+			return "", false, fmt.Errorf("no line after the start marker contains the end marker '%s'", r.Between[1])
+		}
+		if strings.Join(lines[start+1:end], "") == snippet {
+			return content, false, nil
+		}
+		return strings.Join(lines[:start+1], "") + snippet + strings.Join(lines[end:], ""), true, nil
+	}
+
+	if strings.Contains(content, snippet) {
+		return content, false, nil
+	}
+	var match func(string) bool
+	switch {
+	case r.Before != "":
+		match = regexp.MustCompile(r.Before).MatchString
+	case r.After != "":
+		match = regexp.MustCompile(r.After).MatchString
+	case r.BeforeMarker != "":
+		match = func(line string) bool { return strings.Contains(line, r.BeforeMarker) }
+	default:
+		match = func(line string) bool { return strings.Contains(line, r.AfterMarker) }
+	}
+	anchor := findLine(lines, 0, func(line string) bool { return match(strings.TrimRight(line, "\r\n")) })
+	if anchor < 0 {
+		return "", false, fmt.Errorf("no line matches")
+	}
+	at := anchor
+	if r.After != "" || r.AfterMarker != "" {
+		at++
+		// the anchor may be the last line of a file without a trailing newline
+		if !strings.HasSuffix(lines[anchor], "\n") {
+			lines[anchor] += "\n"
+		}
+	}
+	return strings.Join(lines[:at], "") + snippet + strings.Join(lines[at:], ""), true, nil
+}
+
+func findLine(lines []string, from int, match func(string) bool) int {
+	for i := from; i < len(lines); i++ {
+		if match(lines[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// pendingInjection is a rendered snippet that is injected once the rest of the output has been written, since the file
+// it goes into may come later in the template or from a base template.
+type pendingInjection struct {
+	source  string
+	target  string
+	rule    *injectRule
+	snippet string
+}
+
+// manifestInjection returns the injection declared in the manifest of the current layer for a template file, along
+// with the path to inject into. The path is empty when the file's own output path should be used.
+func (p *processor) manifestInjection(templateString string) (*injectRule, string, error) {
+	if len(p.layers) == 0 {
+		return nil, "", nil
+	}
+	layer := p.layers[p.layer]
+	rel := strings.TrimPrefix(path.Clean(templateString), path.Clean(layer.root)+"/")
+	for _, injection := range layer.manifest.Inject {
+		if path.Clean(injection.File) != rel {
+			continue
+		}
+		if injection.Into == "" {
+			return &injection.injectRule, "", nil
+		}
+		src := templatefactory.Source{Path: filepath.Join(layer.root, manifestFileName), Kind: "inject target"}
+		into, err := p.tf.Render(src, injection.Into)
+		if err != nil {
+			return nil, "", renderError(templateString, err, "Error while rendering the injection target of '%s': %w")
+		}
+		into = strings.TrimSpace(into)
+		if into == "" {
+			return nil, "", fmt.Errorf("Error while processing '%s': the injection target evaluated to ''", templateString)
+		}
+		target, err := p.outputPath(templateString, p.layerOutput, into)
+		return &injection.injectRule, target, err
+	}
+	return nil, "", nil
+}
+
+// injectPending applies the queued injections in the order that their files were processed.
+func (p *processor) injectPending() error {
+	for _, injection := range p.injections {
+		if err := p.inject(injection.source, injection.target, injection.rule, injection.snippet, time.Now()); err != nil {
+			return err
+		}
+	}
+	p.injections = nil
+	return nil
+}
+
+// inject inserts rendered content into an existing output file. The file is not recorded as an output of the render
+// since it belongs to whoever created it.
+func (p *processor) inject(templateString, target string, rule *injectRule, snippet string, started time.Time) error {
+	existing, err := p.out.ReadFile(target)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("Error while injecting '%s': '%s' does not exist, injections only change existing files", templateString, target)
+	} else if err != nil {
+		return fmt.Errorf("Error while injecting '%s' into '%s': %s", templateString, target, err)
+	}
+	updated, changed, err := rule.apply(string(existing), snippet)
+	if err != nil {
+		return fmt.Errorf("Error while injecting '%s' into '%s' at %s: %s", templateString, target, rule.describe(), err)
+	}
+	if !changed {
+		logger.Skip(templateString, fmt.Sprintf("its content is already in '%s'", target))
+		return nil
+	}
+	if p.safe != nil {
+		if err := p.safe.countOutput(p, templateString, int64(len(updated))); err != nil {
+			return err
+		}
+	}
+	written, err := p.out.WriteFile(target, []byte(updated))
+	if err != nil {
+		return fmt.Errorf("Error while injecting '%s' into '%s': %s", templateString, target, err)
+	}
+	logger.Inject(templateString, target, written, started)
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInjectRuleApply(t *testing.T) {
+	cases := []struct {
+		name    string
+		rule    injectRule
+		content string
+		snippet string
+		want    string
+	}{
+		{"before", injectRule{Before: `^\}`}, "func f() {\n}\n", "\tx()", "func f() {\n\tx()\n}\n"},
+		{"after", injectRule{After: `^import`}, "package a\nimport \"b\"\n", "import \"c\"\n", "package a\nimport \"b\"\nimport \"c\"\n"},
+		{"first match only", injectRule{After: `^- `}, "- a\n- b\n", "- x", "- a\n- x\n- b\n"},
+		{"pattern ignores the line ending", injectRule{After: `b$`}, "a\r\nb\r\nc\r\n", "x\r\n", "a\r\nb\r\nx\r\nc\r\n"},
+		{"before marker", injectRule{BeforeMarker: "// spiro:routes"}, "a\n  // spiro:routes\nb\n", "  route()", "a\n  route()\n  // spiro:routes\nb\n"},
+		{"after marker", injectRule{AfterMarker: "# spiro"}, "a\n# spiro\nb\n", "x\n", "a\n# spiro\nx\nb\n"},
+		{"after the last line without a newline", injectRule{AfterMarker: "# spiro"}, "a\n# spiro", "x", "a\n# spiro\nx\n"},
+		{"after a regexp on the last line without a newline", injectRule{After: `end`}, "end", "x\n", "end\nx\n"},
+		{"between", injectRule{Between: []string{"# begin", "# end"}}, "a\n# begin\nold\n# end\nb\n", "new", "a\n# begin\nnew\n# end\nb\n"},
+		{"between adjacent markers", injectRule{Between: []string{"# begin", "# end"}}, "# begin\n# end\n", "x\ny\n", "# begin\nx\ny\n# end\n"},
+		{"between with an empty snippet", injectRule{Between: []string{"# begin", "# end"}}, "# begin\nold\n# end\n", "", "# begin\n# end\n"},
+		{"between the same marker text", injectRule{Between: []string{"# gen", "# gen"}}, "# gen\nold\n# gen\n", "new", "# gen\nnew\n# gen\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if err := c.rule.validate(); err != nil {
+				t.Fatalf("invalid rule: %s", err)
+			}
+			got, changed, err := c.rule.apply(c.content, c.snippet)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !changed || got != c.want {
+				t.Fatalf("got %q (changed %t), want %q", got, changed, c.want)
+			}
+			// a second run over its own output must leave the file alone
+			again, changed, err := c.rule.apply(got, c.snippet)
+			if err != nil {
+				t.Fatalf("unexpected error on the second run: %s", err)
+			}
+			if changed || again != got {
+				t.Errorf("second run changed the content to %q", again)
+			}
+		})
+	}
+}
+
+func TestInjectRuleApplyUnchanged(t *testing.T) {
+	cases := []struct {
+		name    string
+		rule    injectRule
+		content string
+		snippet string
+	}{
+		{"before with the snippet elsewhere", injectRule{Before: `^\}`}, "\tx()\nfunc f() {\n}\n", "\tx()"},
+		{"after marker with the snippet elsewhere", injectRule{AfterMarker: "# spiro"}, "x\n# spiro\n", "x\n"},
+		{"between with the same region", injectRule{Between: []string{"# begin", "# end"}}, "# begin\nx\n# end\n", "x"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, changed, err := c.rule.apply(c.content, c.snippet)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if changed || got != c.content {
+				t.Errorf("got %q (changed %t), want the content unchanged", got, changed)
+			}
+		})
+	}
+}
+
+func TestInjectRuleApplyBetweenReplacesStaleRegion(t *testing.T) {
+	// unlike the other positions, between compares the exact region, so a snippet that appears elsewhere in the file
+	// is still injected and a region with extra lines is replaced
+	rule := injectRule{Between: []string{"# begin", "# end"}}
+	got, changed, err := rule.apply("x\n# begin\nx\nstale\n# end\n", "x")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := "x\n# begin\nx\n# end\n"; !changed || got != want {
+		t.Errorf("got %q (changed %t), want %q", got, changed, want)
+	}
+}
+
+func TestInjectRuleApplyErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		rule    injectRule
+		content string
+		want    string
+	}{
+		{"no line matches before", injectRule{Before: `^nothing`}, "a\nb\n", "no line matches"},
+		{"no line matches after", injectRule{After: `^nothing`}, "a\nb\n", "no line matches"},
+		{"missing before marker", injectRule{BeforeMarker: "# spiro"}, "a\n", "no line matches"},
+		{"missing after marker", injectRule{AfterMarker: "# spiro"}, "", "no line matches"},
+		{"missing start marker", injectRule{Between: []string{"# begin", "# end"}}, "a\n# end\n", "no line contains the start marker '# begin'"},
+		{"missing end marker", injectRule{Between: []string{"# begin", "# end"}}, "# begin\na\n", "no line after the start marker contains the end marker '# end'"},
+		{"end marker before the start marker", injectRule{Between: []string{"# begin", "# end"}}, "# end\n# begin\n", "no line after the start marker contains the end marker"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, _, err := c.rule.apply(c.content, "x")
+			if err == nil {
+				t.Fatalf("expected an error containing %q", c.want)
+			}
+			if !strings.Contains(err.Error(), c.want) {
+				t.Errorf("got error %q, want it to contain %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestInjectRuleValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		rule injectRule
+		want string
+	}{
+		{"no position", injectRule{}, "exactly one of"},
+		{"two positions", injectRule{Before: "a", AfterMarker: "b"}, "exactly one of"},
+		{"between with one marker", injectRule{Between: []string{"a"}}, "between must be a list of two markers"},
+		{"between with an empty marker", injectRule{Between: []string{"a", ""}}, "between must be a list of two markers"},
+		{"invalid pattern", injectRule{After: "("}, "invalid pattern '('"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.rule.validate()
+			if err == nil {
+				t.Fatalf("expected an error containing %q", c.want)
+			}
+			if !strings.Contains(err.Error(), c.want) {
+				t.Errorf("got error %q, want it to contain %q", err, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+// The verbosity levels understood by the event logger. Each level includes all of the events of the levels below it.
+const (
+	logLevelQuiet = iota
+	logLevelNormal
+	logLevelVerbose
+)
+
+// The output formats understood by the event logger.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// The operations that are reported as events while processing a template.
+const (
+	opRender = "render"
+	opCopy   = "copy"
+	opMkdir  = "mkdir"
+	opSkip   = "skip"
+	opChmod  = "chmod"
+	opRemove = "remove"
+	opInject = "inject"
+	opWatch  = "watch"
+	opError  = "error"
+	opWarn   = "warning"
+)
+
+// logEvent is a single operation performed by spiro. In json mode each event is written as a single line so that
+// wrappers can consume the stream without having to scrape the human readable text.
+type logEvent struct {
+	Time        string  `json:"time"`
+	Op          string  `json:"op"`
+	Source      string  `json:"source,omitempty"`
+	Destination string  `json:"destination,omitempty"`
+	Bytes       *int64  `json:"bytes,omitempty"`
+	Mode        string  `json:"mode,omitempty"`
+	DurationMs  float64 `json:"duration_ms"`
+	Reason      string  `json:"reason,omitempty"`
+	Message     string  `json:"message,omitempty"`
+	Context     string  `json:"context,omitempty"`
+}
+
+type eventLogger struct {
+	out    io.Writer
+	errOut io.Writer
+	level  int
+	format string
+}
+
+// logger is the process wide event logger, it is configured from the command line flags in mainInner.
+var logger = &eventLogger{out: os.Stdout, errOut: os.Stderr, level: logLevelNormal, format: logFormatText}
+
+func (l *eventLogger) Configure(quiet, verbose bool, format string) error {
+	if quiet && verbose {
+		return fmt.Errorf("The -quiet and -verbose flags cannot be used together")
+	}
+	switch format {
+	case logFormatText, logFormatJSON:
+		l.format = format
+	default:
+		return fmt.Errorf("Unknown log format '%s', expected '%s' or '%s'", format, logFormatText, logFormatJSON)
+	}
+	l.level = logLevelNormal
+	if quiet {
+		l.level = logLevelQuiet
+	} else if verbose {
+		l.level = logLevelVerbose
+	}
+	return nil
+}
+
+func (l *eventLogger) emit(level int, e logEvent) {
+	if level > l.level {
+		return
+	}
+	e.Source = secrets.Redact(e.Source)
+	e.Destination = secrets.Redact(e.Destination)
+	e.Reason = secrets.Redact(e.Reason)
+	e.Message = secrets.Redact(e.Message)
+	e.Context = secrets.Redact(e.Context)
+	if l.format == logFormatJSON {
+		e.Time = time.Now().UTC().Format(time.RFC3339Nano)
+		raw, err := json.Marshal(e)
+		if err != nil {
+			// events are plain structs so this can not happen in practise
+			panic(err)
+		}
+		l.out.Write(append(raw, '\n'))
+		return
+	}
+
+	var line string
+	switch e.Op {
+	case opMkdir:
+		line = fmt.Sprintf("Processing '%s/' -> '%s/'", e.Source, e.Destination)
+	case opRender, opCopy:
+		line = fmt.Sprintf("Processing '%s' -> '%s'", e.Source, e.Destination)
+		if l.level >= logLevelVerbose && e.Bytes != nil {
+			line += fmt.Sprintf(" (%s, %d bytes in %s)", e.Op, *e.Bytes, formatDurationMs(e.DurationMs))
+		}
+	case opSkip:
+		line = fmt.Sprintf("Skipping '%s' since %s", e.Source, e.Reason)
+	case opChmod:
+		line = fmt.Sprintf("Setting mode %s on '%s'", e.Mode, e.Destination)
+	case opInject:
+		line = fmt.Sprintf("Injecting '%s' into '%s'", e.Source, e.Destination)
+		if l.level >= logLevelVerbose && e.Bytes != nil {
+			line += fmt.Sprintf(" (%d bytes in %s)", *e.Bytes, formatDurationMs(e.DurationMs))
+		}
+	case opRemove:
+		line = fmt.Sprintf("Removing '%s' since its source '%s' no longer produces it", e.Destination, e.Source)
+	case opWarn:
+		fmt.Fprintf(l.errOut, "Warning: %s\n", e.Message)
+		return
+	case opError:
+		fmt.Fprintln(l.errOut, e.Message)
+		if e.Context != "" {
+			fmt.Fprintln(l.errOut, e.Context)
+		}
+		return
+	default:
+		line = e.Message
+	}
+	fmt.Fprintln(l.out, line)
+}
+
+func formatDurationMs(ms float64) string {
+	return time.Duration(ms * float64(time.Millisecond)).String()
+}
+
+func durationMs(since time.Time) float64 {
+	return float64(time.Since(since)) / float64(time.Millisecond)
+}
+
+// Mkdir reports that an output directory was created (or already existed) for a template directory.
+func (l *eventLogger) Mkdir(source, destination string, started time.Time) {
+	l.emit(logLevelNormal, logEvent{Op: opMkdir, Source: source, Destination: destination, DurationMs: durationMs(started)})
+}
+
+// Render reports that a templated file was evaluated and written.
+func (l *eventLogger) Render(source, destination string, written int64, started time.Time) {
+	l.emit(logLevelNormal, logEvent{Op: opRender, Source: source, Destination: destination, Bytes: &written, DurationMs: durationMs(started)})
+}
+
+// Copy reports that a file was copied verbatim.
+func (l *eventLogger) Copy(source, destination string, written int64, started time.Time) {
+	l.emit(logLevelNormal, logEvent{Op: opCopy, Source: source, Destination: destination, Bytes: &written, DurationMs: durationMs(started)})
+}
+
+// Skip reports that a template item was not processed along with the reason why.
+func (l *eventLogger) Skip(source, reason string) {
+	l.emit(logLevelNormal, logEvent{Op: opSkip, Source: source, Reason: reason})
+}
+
+// Chmod reports that permission bits were copied to an output file. This is only shown in verbose mode.
+func (l *eventLogger) Chmod(source, destination string, mode os.FileMode, started time.Time) {
+	l.emit(logLevelVerbose, logEvent{Op: opChmod, Source: source, Destination: destination, Mode: fmt.Sprintf("%#o", mode.Perm()), DurationMs: durationMs(started)})
+}
+
+// Unchanged reports a file or directory that was skipped because its inputs have not changed since the last render in
+// watch mode.
+func (l *eventLogger) Unchanged(source string) {
+	l.emit(logLevelVerbose, logEvent{Op: opSkip, Source: source, Reason: "it has not changed"})
+}
+
+// Inject reports that rendered content was inserted into an existing file.
+func (l *eventLogger) Inject(source, destination string, written int64, started time.Time) {
+	l.emit(logLevelNormal, logEvent{Op: opInject, Source: source, Destination: destination, Bytes: &written, DurationMs: durationMs(started)})
+}
+
+// Remove reports an output that was deleted because its source no longer produces it.
+func (l *eventLogger) Remove(source, destination string) {
+	l.emit(logLevelNormal, logEvent{Op: opRemove, Source: source, Destination: destination})
+}
+
+// Watch reports the progress of watch mode.
+func (l *eventLogger) Watch(format string, args ...interface{}) {
+	l.emit(logLevelNormal, logEvent{Op: opWatch, Message: fmt.Sprintf(format, args...)})
+}
+
+// Warn reports a non fatal problem. Warnings are shown unless -quiet is used.
+func (l *eventLogger) Warn(format string, args ...interface{}) {
+	l.emit(logLevelNormal, logEvent{Op: opWarn, Message: fmt.Sprintf(format, args...)})
+}
+
+// Error reports a fatal error. Errors are always shown, even in quiet mode. Template errors are followed by the lines of
+// the templates they occurred in.
+func (l *eventLogger) Error(err error) {
+	l.emit(logLevelQuiet, logEvent{Op: opError, Message: strings.TrimSpace(err.Error()), Context: templateErrorContext(err)})
+}
+
+// templateErrorContext returns the source snippet of each template error in the chain, starting with the outermost so
+// that errors inside included templates read like a stack trace.
+func templateErrorContext(err error) string {
+	var snippets []string
+	for ; err != nil; err = errors.Unwrap(err) {
+		if te, ok := err.(*templatefactory.TemplateError); ok {
+			if snippet := te.Snippet(); snippet != "" {
+				snippets = append(snippets, snippet)
+			}
+		}
+	}
+	return strings.Join(snippets, "\n")
+}
@@ -1,21 +1,15 @@
 package main
 
 import (
-	"bytes"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
-	yaml "gopkg.in/yaml.v2"
-
 	"github.com/astromechza/spiro/templatefactory"
 )
 
@@ -30,13 +24,33 @@ The rule-set is probably a bit complex to display here, but the following links
 
 See the project homepage for more documentation: https://github.com/astromechza/spiro
 
-The spec file should be in JSON or YAML form and will be passed to each template invocation. The specfile can be "-" to
-indicate that YAML should be read from stdin.
+The spec file can be YAML, JSON (comments and trailing commas are allowed), TOML, .env or HCL and will be passed to each
+template invocation. The format is detected from the file extension or given with -spec-format. The specfile can be "-"
+to indicate that the spec should be read from stdin, which is parsed as YAML unless -spec-format is given.
+
+With -batch, the template is rendered once for every document of a multi-document YAML file, every item of a top level
+list or every row of a CSV file, each into a subdirectory of the output directory named by the -batch-dir template.
+
+Use -watch to keep running and render again whenever the template or spec file changes.
+
+Use -safe to render a template you do not trust: plugins, secretEnv and the file reading functions are disabled,
+symlinks are refused and the output is limited by -max-files, -max-output-bytes, -max-depth and -render-timeout.
 
-You can use the -edit flag to edit the spec file in your native $EDITOR before passing it to the templating system.
+You can use the -edit flag to edit the spec file in your $VISUAL or $EDITOR before passing it to the templating system.
 This is useful to avoid the overhead of having to copy and modify an existing source of truth spec file.
 
 $ spiro [options] {input template} {spec file} {output directory}
+
+Templates can also be named from the catalog: the directories and archives in $SPIRO_PATH, ~/.config/spiro/templates
+and the templates listed in ~/.config/spiro/config.yaml.
+
+$ spiro list
+$ spiro new [options] {template name} {spec file} {output directory}
+
+Templates can be tested against golden output with test cases in {template}/.spiro-tests/{case}/, each holding a spec
+file named spec.{yaml,json,...} and the expected output directory named expected/.
+
+$ spiro test [-update] {input template}
 `
 
 const logoImage = `
@@ -51,15 +65,15 @@ const logoImage = `
 // Version is a combination of version information (tag/commit/date/etc)
 var Version = "<unofficial build>"
 
-func copyFileContents(src, dst string) error {
+func copyFileContents(src, dst string) (int64, error) {
 	in, err := os.Open(src)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer in.Close()
 	out, err := os.Create(dst)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer func() {
 		cerr := out.Close()
@@ -67,107 +81,433 @@ func copyFileContents(src, dst string) error {
 			err = cerr
 		}
 	}()
-	if _, err = io.Copy(out, in); err != nil {
-		return err
+	written, err := io.Copy(out, in)
+	if err != nil {
+		return written, err
 	}
-	return out.Sync()
+	return written, out.Sync()
+}
+
+// The kinds of template found in a template tree, used to say which part of a file an error came from.
+const (
+	templateKindName      = "name"
+	templateKindContent   = "content"
+	templateKindPath      = "front matter path"
+	templateKindCondition = "front matter condition"
+)
+
+// nameSource describes the templated base name of a file or directory, positioned within its full path.
+func nameSource(templateString, base string) templatefactory.Source {
+	return templatefactory.Source{Path: templateString, Kind: templateKindName, Column: strings.LastIndex(templateString, base) + 1}
 }
 
-func processDir(templateString string, spec *map[string]interface{}, outputDir string, tf *templatefactory.TemplateFactory) error {
+// processor renders a template tree with a single spec. When it is given the state of a previous render it records
+// what it produces, and files whose inputs have not changed since the previous render are left alone.
+type processor struct {
+	tf         *templatefactory.TemplateFactory
+	spec       *map[string]interface{}
+	out        outputSink
+	root       string
+	outputRoot string
+	// rootContents renders the contents of the root directory straight into the output directory.
+	rootContents bool
+	previous     *renderState
+	current      *renderState
+	treeStamp    string
+
+	// pruneEmptyDirs delays creating directories until something is written into them.
+	pruneEmptyDirs bool
+	pending        []pendingDir
+
+	// layers are the input template and the bases it extends. The layer being processed starts as the input template,
+	// the last layer, and claimed records which layer wrote each output file.
+	layers      []templateLayer
+	layer       int
+	layerOutput string
+	claimed     map[string]layerClaim
+
+	// safe is set in safe mode, outputFiles and outputBytes count towards its limits.
+	safe        *safeLimits
+	outputFiles int
+	outputBytes int64
+
+	// injections wait until every other output of the template and its bases has been written.
+	injections []pendingInjection
+}
+
+// pendingDir is an output directory that has not been created yet because nothing has been written into it.
+type pendingDir struct {
+	source    string
+	outputDir string
+	output    string
+}
+
+func newProcessor(tf *templatefactory.TemplateFactory, spec *map[string]interface{}, settings *renderSettings, outputRoot string, previous *renderState) *processor {
+	p := &processor{
+		tf:             tf,
+		spec:           spec,
+		out:            settings.output,
+		root:           settings.root,
+		outputRoot:     path.Clean(outputRoot),
+		rootContents:   settings.rootContents,
+		pruneEmptyDirs: settings.manifest.pruneEmptyDirs(),
+		previous:       previous,
+		safe:           settings.safe,
+		layers:         settings.layers,
+		layer:          len(settings.layers) - 1,
+		claimed:        make(map[string]layerClaim),
+	}
+	if p.out == nil {
+		p.out = diskOutput{}
+	}
+	if previous != nil {
+		p.current = newRenderState()
+	}
+	return p
+}
+
+func (p *processor) processDir(templateString string, outputDir string) error {
 	fromBase := path.Base(templateString)
 	toBase := fromBase
-	if tf.StringContainsTemplating(fromBase) {
+	if p.rootContents && path.Clean(templateString) == path.Clean(p.root) {
+		toBase = "."
+	} else if p.tf.StringContainsTemplating(fromBase) {
 		var err error
-		toBase, err = tf.Render(fromBase)
+		toBase, err = p.tf.Render(nameSource(templateString, fromBase), fromBase)
 		if err != nil {
-			return fmt.Errorf("Error while processing '%s': %s", templateString, err.Error())
+			return renderError(templateString, err, "Error while rendering the name of '%s': %w")
 		}
 	}
 	toBase = strings.TrimSpace(toBase)
 	if len(toBase) == 0 {
-		fmt.Printf("Skipping '%s' since the name evaluated to ''\n", templateString)
+		logger.Skip(templateString, "the name evaluated to ''")
 		return nil
 	}
 
-	newOutputDir := path.Join(outputDir, toBase)
-	fmt.Printf("Processing '%s/' -> '%s/'\n", templateString, newOutputDir)
-	if err := os.Mkdir(newOutputDir, 0755); err != nil && !os.IsExist(err) {
-		return fmt.Errorf("Error while processing '%s': %s", templateString, err.Error())
+	newOutputDir, err := p.outputPath(templateString, outputDir, toBase)
+	if err != nil {
+		return err
+	}
+	if p.overlaid(templateString, newOutputDir, true) {
+		return nil
 	}
+	pendingIndex := len(p.pending)
+	p.pending = append(p.pending, pendingDir{source: templateString, outputDir: outputDir, output: newOutputDir})
+	if !p.pruneEmptyDirs {
+		if err := p.createPendingDirs(); err != nil {
+			return err
+		}
+	}
+
+	if path.Clean(templateString) == path.Clean(p.root) {
+		p.layerOutput = newOutputDir
+	}
+	if err := p.processItems(templateString, newOutputDir); err != nil {
+		return err
+	}
+	if len(p.layers) > 1 && path.Clean(templateString) == path.Clean(p.root) {
+		if err := p.processBases(newOutputDir); err != nil {
+			return err
+		}
+	}
+
+	// when the directory is still pending nothing was written into it
+	if len(p.pending) > pendingIndex {
+		if _, err := os.Stat(path.Join(templateString, keepMarkerFileName)); err == nil {
+			return p.createPendingDirs()
+		}
+		p.pending = p.pending[:pendingIndex]
+		logger.Skip(templateString, "it would be empty")
+	}
+	return nil
+}
 
+// processItems processes the contents of a template directory into the output directory.
+func (p *processor) processItems(templateString, outputDir string) error {
 	items, err := ioutil.ReadDir(templateString)
 	if err != nil {
 		return fmt.Errorf("Error while reading '%s': %s", templateString, err.Error())
 	}
 	for _, item := range items {
-		if err := process(path.Join(templateString, item.Name()), spec, newOutputDir, tf); err != nil {
+		if item.Name() == manifestFileName {
+			logger.Skip(path.Join(templateString, item.Name()), "it is a template manifest")
+			continue
+		}
+		if p.isTestsDir(path.Join(templateString, item.Name())) {
+			logger.Skip(path.Join(templateString, item.Name()), "it holds the template tests")
+			continue
+		}
+		if p.isLayerRoot(path.Join(templateString, item.Name())) {
+			// bases inside the template are overlaid by processBases rather than rendered as a subdirectory
+			logger.Skip(path.Join(templateString, item.Name()), "it is a base template")
+			continue
+		}
+		if item.Name() == keepMarkerFileName && p.pruneEmptyDirs {
+			continue
+		}
+		if err := p.process(path.Join(templateString, item.Name()), outputDir); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func processFile(templateString string, spec *map[string]interface{}, outputDir string, tf *templatefactory.TemplateFactory) error {
+// createPendingDirs creates the output directories that are waiting for their first child, outermost first.
+func (p *processor) createPendingDirs() error {
+	for _, dir := range p.pending {
+		if p.unchanged(dir.output, dir.source) {
+			logger.Unchanged(dir.source)
+		} else {
+			if err := p.createParents(dir.source, dir.outputDir, dir.output); err != nil {
+				return err
+			}
+			started := time.Now()
+			if err := p.out.Mkdir(dir.output); err != nil {
+				return fmt.Errorf("Error while processing '%s': %s", dir.source, err.Error())
+			}
+			logger.Mkdir(dir.source, dir.output, started)
+		}
+		p.record(dir.output, dir.source, dir.source, true)
+	}
+	p.pending = p.pending[:0]
+	return nil
+}
+
+func (p *processor) processFile(templateString string, outputDir string) error {
 	fromBase := path.Base(templateString)
 	toBase := fromBase
-	if tf.StringContainsTemplating(fromBase) {
+	if p.tf.StringContainsTemplating(fromBase) {
 		var err error
-		toBase, err = tf.Render(fromBase)
+		toBase, err = p.tf.Render(nameSource(templateString, fromBase), fromBase)
 		if err != nil {
-			return fmt.Errorf("Error while processing '%s': %s", templateString, err.Error())
+			return renderError(templateString, err, "Error while rendering the name of '%s': %w")
 		}
 	}
 	toBase = strings.TrimSpace(toBase)
 	if len(toBase) == 0 {
-		fmt.Printf("Skipping '%s' since the name evaluated to ''\n", templateString)
+		logger.Skip(templateString, "the name evaluated to ''")
 		return nil
 	}
 
-	if strings.HasSuffix(toBase, ".templated") {
+	started := time.Now()
+	templated := strings.HasSuffix(toBase, ".templated")
+	if templated {
 		toBase = toBase[:len(toBase)-10]
 		if len(toBase) == 0 {
-			fmt.Printf("Skipping '%s' since the name evaluated to ''\n", templateString)
+			logger.Skip(templateString, "the name evaluated to ''")
 			return nil
 		}
-		fmt.Printf("Processing '%s' -> '%s'\n", templateString, path.Join(outputDir, toBase))
-		inputBytes, err := ioutil.ReadFile(templateString)
-		if err != nil {
+	}
+
+	info, err := os.Stat(templateString)
+	if err != nil {
+		return fmt.Errorf("Error while checking file permissions for '%s': %s", templateString, err.Error())
+	}
+	mode := info.Mode()
+	var inputBytes, body []byte
+	var fm *frontMatter
+	bodyStart := position{line: 1, column: 1}
+	if templated {
+		if inputBytes, err = ioutil.ReadFile(templateString); err != nil {
 			return fmt.Errorf("Error while reading '%s': %s", templateString, err.Error())
 		}
-		outputBytes, err := tf.Render(string(inputBytes))
+		if fm, body, err = parseFrontMatter(inputBytes); err != nil {
+			return fmt.Errorf("Error while processing '%s': %s", templateString, err.Error())
+		}
+		if fm != nil {
+			bodyStart = fm.bodyStart
+		}
+	}
+	if fm != nil {
+		if ok, err := fm.condition(p, templateString); err != nil {
+			return renderError(templateString, err, "Error while evaluating the front matter condition of '%s': %w")
+		} else if !ok {
+			logger.Skip(templateString, "its front matter condition is false")
+			return nil
+		}
+		if fm.Path != "" {
+			if toBase, err = p.tf.Render(fm.pathStart.source(templateString, templateKindPath), fm.Path); err != nil {
+				return renderError(templateString, err, "Error while rendering the front matter path of '%s': %w")
+			}
+			if toBase = strings.TrimSpace(toBase); toBase == "" {
+				logger.Skip(templateString, "the front matter path evaluated to ''")
+				return nil
+			}
+		}
+		if fmMode, ok, err := fm.fileMode(); err != nil {
+			return fmt.Errorf("Error while processing '%s': %s", templateString, err.Error())
+		} else if ok {
+			mode = fmMode
+		}
+	}
+	outputPath, err := p.outputPath(templateString, outputDir, toBase)
+	if err != nil {
+		return err
+	}
+	if p.overlaid(templateString, outputPath, false) {
+		return nil
+	}
+	rule, target := (*injectRule)(nil), outputPath
+	if fm != nil && fm.Inject != nil {
+		rule = fm.Inject
+	} else if manifestRule, into, err := p.manifestInjection(templateString); err != nil {
+		return err
+	} else if manifestRule != nil {
+		rule = manifestRule
+		if into != "" {
+			target = into
+		}
+	}
+
+	referenced := inputBytes
+	if fm != nil {
+		referenced = fm.referenceText(p.tf, body)
+	}
+	fingerprint := p.fingerprint(templateString, info, outputPath, referenced, templated)
+	if p.unchanged(outputPath, fingerprint) {
+		if err := p.createOutputDirs(templateString, outputDir, outputPath); err != nil {
+			return err
+		}
+		logger.Unchanged(templateString)
+		p.claim(templateString, outputPath)
+		p.record(outputPath, templateString, fingerprint, false)
+		return nil
+	}
+
+	if rule != nil {
+		// injections change a file that already exists, so they are applied on every render
+		snippet := string(inputBytes)
+		if templated {
+			if snippet, err = p.tf.Render(bodyStart.source(templateString, templateKindContent), string(body)); err != nil {
+				return renderError(templateString, err, "Error while rendering template for '%s': %w")
+			}
+		} else if content, err := ioutil.ReadFile(templateString); err != nil {
+			return fmt.Errorf("Error while reading '%s': %s", templateString, err.Error())
+		} else {
+			snippet = string(content)
+		}
+		if fm != nil && fm.SkipIfEmpty && strings.TrimSpace(snippet) == "" {
+			logger.Skip(templateString, "the rendered content is empty")
+			return nil
+		}
+		p.injections = append(p.injections, pendingInjection{source: templateString, target: target, rule: rule, snippet: snippet})
+		return nil
+	}
+
+	if templated {
+		outputBytes, err := p.tf.Render(bodyStart.source(templateString, templateKindContent), string(body))
 		if err != nil {
-			return fmt.Errorf("Error while rendering template for '%s': %s", templateString, err.Error())
+			return renderError(templateString, err, "Error while rendering template for '%s': %w")
+		}
+		if fm != nil && fm.SkipIfEmpty && strings.TrimSpace(outputBytes) == "" {
+			logger.Skip(templateString, "the rendered content is empty")
+			return nil
+		}
+		if p.safe != nil {
+			if err := p.safe.countOutput(p, templateString, int64(len(outputBytes))); err != nil {
+				return err
+			}
+		}
+		if err := p.createOutputDirs(templateString, outputDir, outputPath); err != nil {
+			return err
 		}
-		if err := ioutil.WriteFile(path.Join(outputDir, toBase), []byte(outputBytes), 0644); err != nil {
+		written, err := p.out.WriteFile(outputPath, []byte(outputBytes))
+		if err != nil {
 			return fmt.Errorf("Error while writing file bytes for '%s': %s", templateString, err.Error())
 		}
+		logger.Render(templateString, outputPath, written, started)
 	} else {
-		fmt.Printf("Processing '%s' -> '%s'\n", templateString, path.Join(outputDir, toBase))
-		if err := copyFileContents(templateString, path.Join(outputDir, toBase)); err != nil {
+		if p.safe != nil {
+			if err := p.safe.countOutput(p, templateString, info.Size()); err != nil {
+				return err
+			}
+		}
+		if err := p.createOutputDirs(templateString, outputDir, outputPath); err != nil {
+			return err
+		}
+		written, err := p.out.CopyFile(templateString, outputPath)
+		if err != nil {
 			return fmt.Errorf("Error while copying file bytes for '%s': %s", templateString, err.Error())
 		}
+		logger.Copy(templateString, outputPath, written, started)
 	}
 
-	info, err := os.Stat(templateString)
-	if err != nil {
-		return fmt.Errorf("Error while checking file permissions for '%s': %s", templateString, err.Error())
-	}
-	if err := os.Chmod(path.Join(outputDir, toBase), info.Mode()); err != nil {
+	started = time.Now()
+	if err := p.out.Chmod(outputPath, mode); err != nil {
 		return fmt.Errorf("Error while writing file permissions for '%s': %s", templateString, err.Error())
 	}
+	logger.Chmod(templateString, outputPath, mode, started)
 
+	p.claim(templateString, outputPath)
+	p.record(outputPath, templateString, fingerprint, false)
 	return nil
 }
 
-func process(templateString string, spec *map[string]interface{}, outputDir string, tf *templatefactory.TemplateFactory) error {
+// outputPath joins a rendered name onto the output directory. A name may contain slashes, so that a path like a Go
+// package can be expressed in a single name, but the result must stay inside the output directory. An input template
+// named "." is the exception: its contents are rendered straight into the output directory.
+func (p *processor) outputPath(templateString, outputDir, name string) (string, error) {
+	target := path.Join(outputDir, name)
+	if !isWithin(p.outputRoot, target) {
+		return "", fmt.Errorf("Error while processing '%s': the name '%s' resolves to '%s' which is outside of the output directory '%s'", templateString, name, target, p.outputRoot)
+	}
+	if name == "." && path.Clean(templateString) == path.Clean(p.root) {
+		return target, nil
+	}
+	if target == path.Clean(outputDir) || !isWithin(path.Clean(outputDir), path.Dir(target)) {
+		return "", fmt.Errorf("Error while processing '%s': the name '%s' must resolve to a path inside '%s'", templateString, name, outputDir)
+	}
+	if p.safe != nil {
+		if err := p.safe.checkOutputPath(templateString, p.outputRoot, target); err != nil {
+			return "", err
+		}
+	}
+	return target, nil
+}
+
+// createOutputDirs creates the pending directories and any intermediate directories of a file that is about to be
+// written.
+func (p *processor) createOutputDirs(templateString, outputDir, target string) error {
+	if err := p.createPendingDirs(); err != nil {
+		return err
+	}
+	return p.createParents(templateString, outputDir, target)
+}
+
+// createParents creates the intermediate directories between the output directory and a target whose name contained
+// slashes.
+func (p *processor) createParents(templateString, outputDir, target string) error {
+	parent := path.Dir(target)
+	if parent == path.Clean(outputDir) || target == path.Clean(outputDir) {
+		return nil
+	}
+	for dir := parent; dir != path.Clean(outputDir); dir = path.Dir(dir) {
+		p.record(dir, templateString, templateString, true)
+	}
+	if err := p.out.MkdirAll(parent); err != nil {
+		return fmt.Errorf("Error while processing '%s': %s", templateString, err.Error())
+	}
+	return nil
+}
+
+func (p *processor) process(templateString string, outputDir string) error {
+	if p.safe != nil {
+		stat, err := os.Lstat(templateString)
+		if err != nil {
+			return fmt.Errorf("Error processing template %s: %s", templateString, err.Error())
+		}
+		if err := p.safe.checkSymlink(templateString, stat); err != nil {
+			return err
+		}
+	}
 	stat, err := os.Stat(templateString)
 	if err != nil {
 		return fmt.Errorf("Error processing template %s: %s", templateString, err.Error())
 	}
 	if stat.IsDir() {
-		return processDir(templateString, spec, outputDir, tf)
+		return p.processDir(templateString, outputDir)
 	}
-	return processFile(templateString, spec, outputDir, tf)
+	return p.processFile(templateString, outputDir)
 }
 
 func readSpecRaw(specFile string) ([]byte, error) {
@@ -181,52 +521,143 @@ func readSpecRaw(specFile string) ([]byte, error) {
 	return content, nil
 }
 
-// Build an integer from a version string. The version string can contain 3 numbers and each number can be a maximum
-// of 999. 1.2.3 -> 100200300.
-func buildVersionInt(versionString string) (uint64, error) {
-	parts := strings.Split(versionString, ".")
-	var value uint64
-	for index := 0; index < 3; index++ {
-		if len(parts) > index {
-			v, err := strconv.Atoi(parts[index])
-			if v < 0 {
-				v = 0
-			} else if v > 999 {
-				v = 999
-			}
-			if err != nil {
-				return value, fmt.Errorf("Could not parse version part %s in %s", parts[index], versionString)
-			}
-			value += uint64(v)
-		}
-		value *= 1000
-	}
-	return value, nil
+// registerTemplateFunctions adds the builtin template functions to the template factory. These are available in both
+// file names and file contents.
+func registerTemplateFunctions(tf *templatefactory.TemplateFactory) {
+	tf.RegisterTemplateFunction("title", strings.Title)
+	tf.RegisterTemplateFunction("lower", strings.ToLower)
+	tf.RegisterTemplateFunction("upper", strings.ToUpper)
+	tf.RegisterTemplateFunction("snake", SnakeCase)
+	tf.RegisterTemplateFunction("kebab", KebabCase)
+	tf.RegisterTemplateFunction("camel", CamelCase)
+	tf.RegisterTemplateFunction("pascal", PascalCase)
+	tf.RegisterTemplateFunction("screamingSnake", ScreamingSnakeCase)
+	tf.RegisterTemplateFunction("dotCase", DotCase)
+	tf.RegisterTemplateFunction("slug", Slug)
+	tf.RegisterTemplateFunction("initials", Initials)
+	tf.RegisterTemplateFunction("json", Jsonify)
+	tf.RegisterTemplateFunction("jsonindent", JsonifyIndent)
+	tf.RegisterTemplateFunction("toJson", ToJSON)
+	tf.RegisterTemplateFunction("toPrettyJson", ToPrettyJSON)
+	tf.RegisterTemplateFunction("toYaml", ToYAML)
+	tf.RegisterTemplateFunction("toToml", ToTOML)
+	tf.RegisterTemplateFunction("fromJson", FromJSON)
+	tf.RegisterTemplateFunction("fromYaml", FromYAML)
+	tf.RegisterTemplateFunction("unescape", Unescape)
+	tf.RegisterTemplateFunction("stringreplace", StringReplace)
+	tf.RegisterTemplateFunction("regexreplace", RegexReplace)
+	tf.RegisterTemplateFunction("add", Add)
+	tf.RegisterTemplateFunction("sub", Sub)
+	tf.RegisterTemplateFunction("mul", Mul)
+	tf.RegisterTemplateFunction("div", Div)
+	tf.RegisterTemplateFunction("mod", Mod)
+	tf.RegisterTemplateFunction("min", Min)
+	tf.RegisterTemplateFunction("max", Max)
+	tf.RegisterTemplateFunction("round", Round)
+	tf.RegisterTemplateFunction("floor", Floor)
+	tf.RegisterTemplateFunction("ceil", Ceil)
+	tf.RegisterTemplateFunction("int", ToInt)
+	tf.RegisterTemplateFunction("float", ToFloat)
+	tf.RegisterTemplateFunction("seq", Seq)
+	tf.RegisterTemplateFunction("until", Until)
+	tf.RegisterTemplateFunction("default", Default)
+	tf.RegisterTemplateFunction("coalesce", Coalesce)
+	tf.RegisterTemplateFunction("ternary", Ternary)
+	tf.RegisterTemplateFunction("dict", Dict)
+	tf.RegisterTemplateFunction("list", List)
+	tf.RegisterTemplateFunction("keys", Keys)
+	tf.RegisterTemplateFunction("values", Values)
+	tf.RegisterTemplateFunction("hasKey", HasKey)
+	tf.RegisterTemplateFunction("sortAlpha", SortAlpha)
+	tf.RegisterTemplateFunction("uniq", Uniq)
+	tf.RegisterTemplateFunction("pluck", Pluck)
+	tf.RegisterTemplateFunction("first", First)
+	tf.RegisterTemplateFunction("last", Last)
+	tf.RegisterTemplateFunction("join", Join)
+	tf.RegisterTemplateFunction("secretEnv", SecretEnv)
+	tf.RegisterTemplateFunction("skipFile", SkipFile)
+	tf.RegisterTemplateFunction("fail", Fail)
+	tf.RegisterTemplateFunction("required", Required)
 }
 
-// This function compares the current version to the one in the spec file. If the running version is too low, return
-// an error. The version numbers are compared as integers.
-func checkVersionIfNecessary(spec *map[string]interface{}) error {
-	if minVersion, ok := (*spec)["_spiro_min_version_"]; ok {
-		if minVersionString, ok := minVersion.(string); ok {
+// renderSettings holds the inputs that are shared by every spec rendered in a single invocation.
+type renderSettings struct {
+	inputTemplate string
+	root          string
+	clock         *clock
+	config        *userConfig
+	manifest      *templateManifest
+	// output is where rendered files are written, the file system when it is nil.
+	output outputSink
+	// safe restricts what the template can do, it is nil unless -safe is given.
+	safe *safeLimits
+	// layers are the input template directory and the bases it extends, bases first. The manifest is the merge of
+	// their manifests.
+	layers []templateLayer
+	// plugins are started once and provide template functions to every spec.
+	plugins *pluginSet
+	// bases are the base templates fetched while loading the layers, kept so that they are only fetched once.
+	bases *fetchedBases
+	// rootContents renders the contents of the input template directory straight into the output directory rather
+	// than into a directory named after it.
+	rootContents bool
+}
 
-			// extract 3 digit version from Version
-			match := regexp.MustCompile(`v(\d+\.\d+\.\d+)`).FindStringSubmatch(Version)
-			if match == nil {
-				return fmt.Errorf("You are running an unofficial build of Spiro: we cannot handle version matches")
-			}
-			currentVersion := match[1]
+// renderSpec renders the input template with a single spec. The output directory is chosen by the given function once
+// the template factory for the spec has been set up, which allows batch mode to template the directory name.
+//
+// When the state of a previous render is given, files whose inputs have not changed are skipped, outputs that are no
+// longer produced are removed and the state of this render is returned.
+func renderSpec(spec map[string]interface{}, settings *renderSettings, outputDirectory func(*templatefactory.TemplateFactory) (string, error), previous *renderState) (*renderState, error) {
+	if err := registerSpecSecrets(spec); err != nil {
+		return previous, err
+	}
+	if err := checkVersionIfNecessary(&spec); err != nil {
+		return previous, err
+	}
 
-			if minVersionValue, err := buildVersionInt(minVersionString); err != nil {
-				return err
-			} else if currentVersionValue, err := buildVersionInt(currentVersion); err != nil {
-				return err
-			} else if currentVersionValue < minVersionValue {
-				return fmt.Errorf("Spiro template lists minimum version %s but you're using %s!", minVersionString, Version)
-			}
+	tf := templatefactory.NewTemplateFactory()
+	if err := tf.SetSpec(&spec); err != nil {
+		return previous, err
+	}
+	registerTemplateFunctions(tf)
+	registerFileFunctions(tf, layerRoots(settings.root, settings.layers))
+	registerTimeFunctions(tf, settings.clock)
+
+	if settings.plugins != nil {
+		settings.plugins.register(tf)
+	}
+	if settings.safe != nil {
+		settings.safe.apply(tf)
+	}
+
+	vars, err := collectVars(settings.manifest, spec)
+	if err != nil {
+		return previous, err
+	}
+	if err := evaluateVars(tf, &spec, vars); err != nil {
+		return previous, err
+	}
+
+	outputDir, err := outputDirectory(tf)
+	if err != nil {
+		return previous, err
+	}
+	p := newProcessor(tf, &spec, settings, outputDir, previous)
+	err = p.process(settings.inputTemplate, outputDir)
+	if err == nil {
+		err = p.injectPending()
+	}
+	if err != nil {
+		if previous != nil {
+			return previous.merge(p.current), err
 		}
+		return nil, err
 	}
-	return nil
+	if previous != nil {
+		removeStaleOutputs(previous, p.current)
+	}
+	return p.current, nil
 }
 
 func mainInner() error {
@@ -234,6 +665,20 @@ func mainInner() error {
 	// first set up config flag options
 	versionFlag := flag.Bool("version", false, "Print the version string")
 	editFlag := flag.Bool("edit", false, "Open the spec file in your $EDITOR before passing it on to the main routine")
+	quietFlag := flag.Bool("quiet", false, "Only print errors")
+	verboseFlag := flag.Bool("verbose", false, "Print every operation including permission changes, byte counts and durations")
+	specFormatFlag := flag.String("spec-format", "", "The format of the spec file: yaml, json, toml, env, hcl or csv (default: detected from the file extension)")
+	batchFlag := flag.Bool("batch", false, "Render the template once per spec: per YAML document, CSV row or top level list item")
+	batchDirFlag := flag.String("batch-dir", defaultBatchDirTemplate, "In batch mode, a template for the name of the output subdirectory of each spec")
+	updateFlag := flag.Bool("update", false, "With 'spiro test', replace the expected output of each test case with the rendered output")
+	watchFlag := flag.Bool("watch", false, "Keep running and render again whenever the template or spec file changes")
+	nowFlag := flag.String("now", "", "Pin the time returned by the time functions to an RFC3339 timestamp (overrides $SOURCE_DATE_EPOCH)")
+	logFormatFlag := flag.String("log-format", logFormatText, "The format of the event log: 'text' or 'json' (one event per line)")
+	safeFlag := flag.Bool("safe", false, "Render an untrusted template: disable plugins, secretEnv and file reading functions, refuse symlinks and limit the output")
+	maxOutputBytesFlag := flag.Int64("max-output-bytes", defaultSafeMaxOutputBytes, "With -safe, the most bytes that may be written in total")
+	maxFilesFlag := flag.Int("max-files", defaultSafeMaxFiles, "With -safe, the most files that may be written")
+	maxDepthFlag := flag.Int("max-depth", defaultSafeMaxDepth, "With -safe, the deepest an output path may be nested below the output directory")
+	renderTimeoutFlag := flag.Duration("render-timeout", defaultSafeRenderTimeout, "With -safe, the longest that rendering a single template may take")
 
 	// set a more verbose usage message.
 	flag.Usage = func() {
@@ -243,24 +688,66 @@ func mainInner() error {
 	// parse them
 	flag.Parse()
 
+	// `spiro new` and `spiro test` also accept flags after the subcommand. Three arguments without a flag after the
+	// first are a template directory that happens to share the name of a subcommand.
+	subcommand := ""
+	flagFollows := strings.HasPrefix(flag.Arg(1), "-") && flag.Arg(1) != "-"
+	if flag.NArg() > 0 && (flag.Arg(0) == "new" || flag.Arg(0) == "test") && (flag.NArg() != 3 || flagFollows) {
+		subcommand = flag.Arg(0)
+		if err := flag.CommandLine.Parse(flag.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	newFromCatalog := subcommand == "new"
+
+	if err := logger.Configure(*quietFlag, *verboseFlag, *logFormatFlag); err != nil {
+		return err
+	}
+
 	// do arg checking
 	if *versionFlag {
 		fmt.Printf("Version: %s\n", Version)
-		fmt.Println(logoImage)
+		fmt.Print(logoImage + "\n")
 		fmt.Println("Project: github.com/astromechza/spiro")
 		return nil
 	}
+	config, err := loadUserConfig()
+	if err != nil {
+		return err
+	}
+	if subcommand == "" && flag.NArg() == 1 && flag.Arg(0) == "list" {
+		return printCatalog(config, os.Stdout)
+	}
+	if subcommand == "test" {
+		if flag.NArg() != 1 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		return testTemplate(flag.Arg(0), config, *nowFlag, *updateFlag)
+	}
 	if flag.NArg() != 3 {
 		flag.Usage()
 		os.Exit(1)
 	}
 
+	templateClock, err := newClock(*nowFlag)
+	if err != nil {
+		return err
+	}
+
 	inputTemplate := flag.Arg(0)
 	specFile := flag.Arg(1)
 	outputDirectory := flag.Arg(2)
 
+	inputTemplate, cleanup, err := resolveInputTemplate(config, inputTemplate, newFromCatalog)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
 	// ensure template files/dir exists
-	if _, err := os.Stat(inputTemplate); err != nil {
+	inputStat, err := os.Stat(inputTemplate)
+	if err != nil {
 		if os.IsNotExist(err) {
 			return fmt.Errorf("Input template '%s' does not exist!", inputTemplate)
 		}
@@ -286,91 +773,96 @@ func mainInner() error {
 		return fmt.Errorf("Output directory '%s' cannot be a file!", specFile)
 	}
 
+	if *watchFlag {
+		if *batchFlag || *editFlag || specFile == "-" {
+			return fmt.Errorf("-watch cannot be combined with -batch, -edit or reading the spec from stdin")
+		}
+	}
+
+	specFormat, err := detectSpecFormat(specFile, *specFormatFlag)
+	if err != nil {
+		return err
+	}
+
 	specContents, err := readSpecRaw(specFile)
 	if err != nil {
 		return err
 	}
 
-	// TODO check and do edit here
 	if *editFlag {
-		editor := os.Getenv("EDITOR")
-		if editor == "" {
-			return fmt.Errorf("You specified --edit but no $EDITOR is available")
-		}
-
-		var tf *os.File
-		tf, err = ioutil.TempFile(os.TempDir(), "spiro")
-		defer os.Remove(tf.Name())
-		if err != nil {
-			return fmt.Errorf("Unable to setup temporary file for editting: %s", err)
-		}
-		if _, err = tf.Write(specContents); err != nil {
-			return fmt.Errorf("Failed to write bytes to temporary file: %s", err)
+		validate := func(content []byte) error {
+			if *batchFlag {
+				_, err := decodeSpecBatch(content, specFormat)
+				return err
+			}
+			_, err := decodeSpec(content, specFormat)
+			return err
 		}
-		err = tf.Close()
-		if err != nil {
-			panic(err)
+		if specContents, err = editSpec(specContents, specFormat, validate); err != nil {
+			return err
 		}
+	}
 
-		var fi os.FileInfo
-		fi, err = os.Stat(tf.Name())
-		if err != nil {
-			panic(err)
+	settings := &renderSettings{
+		inputTemplate: inputTemplate,
+		root:          templateRoot(inputTemplate, inputStat.IsDir()),
+		clock:         templateClock,
+		config:        config,
+		manifest:      &templateManifest{},
+	}
+	if *safeFlag {
+		settings.safe = &safeLimits{
+			maxOutputBytes: *maxOutputBytesFlag,
+			maxFiles:       *maxFilesFlag,
+			maxDepth:       *maxDepthFlag,
+			renderTimeout:  *renderTimeoutFlag,
 		}
-		beforeTime := fi.ModTime()
-
-		cmd := exec.Command(editor, tf.Name())
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Env = os.Environ()
-		if err = cmd.Run(); err != nil {
-			return fmt.Errorf("Editor command failed: %s", err)
+	}
+	if inputStat.IsDir() {
+		if settings.safe != nil {
+			if info, err := os.Lstat(path.Join(inputTemplate, manifestFileName)); err == nil {
+				if err := settings.safe.checkSymlink(path.Join(inputTemplate, manifestFileName), info); err != nil {
+					return err
+				}
+			}
 		}
-
-		fi, err = os.Stat(tf.Name())
+		cleanupLayers, err := settings.loadLayers()
+		defer cleanupLayers()
 		if err != nil {
-			panic(err)
+			return err
 		}
-		afterTime := fi.ModTime()
+	}
+	stopPlugins, err := settings.startPlugins()
+	defer stopPlugins()
+	if err != nil {
+		return err
+	}
 
-		if beforeTime == afterTime {
-			return fmt.Errorf("No save detected, you must save the file when using -edit")
-		}
+	if *watchFlag {
+		return watch(specFile, specFormat, outputDirectory, settings)
+	}
 
-		specContents, err = readSpecRaw(tf.Name())
+	if *batchFlag {
+		specs, err := decodeSpecBatch(specContents, specFormat)
 		if err != nil {
 			return err
 		}
+		return renderBatch(specs, outputDirectory, *batchDirFlag, settings)
 	}
 
-	var spec map[string]interface{}
-	dec := yaml.NewDecoder(bytes.NewReader(specContents))
-	err = dec.Decode(&spec)
+	spec, err := decodeSpec(specContents, specFormat)
 	if err != nil {
-		return fmt.Errorf("Could not parse spec file: %s", err.Error())
-	}
-
-	tf := templatefactory.NewTemplateFactory()
-	if err := tf.SetSpec(&spec); err != nil {
 		return err
 	}
-	tf.RegisterTemplateFunction("title", strings.Title)
-	tf.RegisterTemplateFunction("lower", strings.ToLower)
-	tf.RegisterTemplateFunction("upper", strings.ToUpper)
-	tf.RegisterTemplateFunction("now", time.Now)
-	tf.RegisterTemplateFunction("json", Jsonify)
-	tf.RegisterTemplateFunction("jsonindent", JsonifyIndent)
-	tf.RegisterTemplateFunction("unescape", Unescape)
-	tf.RegisterTemplateFunction("stringreplace", StringReplace)
-	tf.RegisterTemplateFunction("regexreplace", RegexReplace)
-	tf.RegisterTemplateFunction("add", Add)
-	return process(inputTemplate, &spec, outputDirectory, tf)
+	_, err = renderSpec(spec, settings, func(*templatefactory.TemplateFactory) (string, error) {
+		return outputDirectory, nil
+	}, nil)
+	return err
 }
 
 func main() {
 	if err := mainInner(); err != nil {
-		os.Stderr.WriteString(err.Error() + "\n")
+		logger.Error(err)
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// keepMarkerFileName marks a template directory that is created even when nothing is rendered into it. The marker is
+// not copied when empty directories are pruned.
+const keepMarkerFileName = ".keep"
+
+// manifestFileName is the name of the optional manifest in the root of a template directory. Files with this name are
+// never copied to the output.
+const manifestFileName = ".spiro.yaml"
+
+// templateManifest describes a template directory.
+type templateManifest struct {
+	// Description is shown by `spiro list`.
+	Description string `yaml:"description"`
+	// Vars are derived variables evaluated against the spec before rendering, in declaration order.
+	Vars yaml.MapSlice `yaml:"vars"`
+	// PruneEmptyDirs removes directories that end up empty. It defaults to true for templates that have a manifest.
+	PruneEmptyDirs *bool `yaml:"prune_empty_dirs"`
+	// Extends lists the base templates that this template is overlaid onto: local paths relative to this template,
+	// archives or git sources. Later bases override earlier ones and this template overrides them all.
+	Extends []string `yaml:"extends"`
+	// Delete lists output paths, relative to the template's output and with glob patterns, that the base templates
+	// should not produce.
+	Delete []string `yaml:"delete"`
+	// MinVersion and Version are version requirements for spiro, like the _spiro_min_version_ and _spiro_version_
+	// spec keys.
+	MinVersion string `yaml:"min_version"`
+	Version    string `yaml:"version"`
+	// Plugins are plugin executables that provide template functions, resolved relative to the template root.
+	Plugins []pluginConfig `yaml:"plugins"`
+	// Inject turns template files into snippets that are inserted into existing output files.
+	Inject []manifestInjection `yaml:"inject"`
+
+	found bool
+}
+
+// pruneEmptyDirs reports whether directories that end up empty should be removed. Templates without a manifest keep
+// the original behaviour of always creating every directory.
+func (m *templateManifest) pruneEmptyDirs() bool {
+	if m.PruneEmptyDirs != nil {
+		return *m.PruneEmptyDirs
+	}
+	return m.found
+}
+
+// loadManifest reads the manifest from the root of a template directory. A missing manifest is an empty one.
+func loadManifest(root string) (*templateManifest, error) {
+	manifest := &templateManifest{}
+	path := filepath.Join(root, manifestFileName)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return manifest, nil
+		}
+		return nil, fmt.Errorf("Could not read template manifest '%s': %s", path, err)
+	}
+	if err := yaml.UnmarshalStrict(content, manifest); err != nil {
+		return nil, fmt.Errorf("Could not parse template manifest '%s': %s", path, err)
+	}
+	for i, injection := range manifest.Inject {
+		if injection.File == "" {
+			return nil, fmt.Errorf("Invalid injection %d in template manifest '%s': the file must be given", i+1, path)
+		}
+		if err := injection.validate(); err != nil {
+			return nil, fmt.Errorf("Invalid injection of '%s' in template manifest '%s': %s", injection.File, path, err)
+		}
+	}
+	manifest.found = true
+	return manifest, nil
+}
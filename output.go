@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// outputSink is where a render writes its output: the file system, or memory when testing templates.
+type outputSink interface {
+	// Mkdir creates a directory, it is not an error when the directory already exists.
+	Mkdir(dir string) error
+	MkdirAll(dir string) error
+	WriteFile(filePath string, content []byte) (int64, error)
+	CopyFile(src, dst string) (int64, error)
+	Chmod(filePath string, mode os.FileMode) error
+	Exists(filePath string) bool
+	ReadFile(filePath string) ([]byte, error)
+}
+
+// diskOutput writes to the file system.
+type diskOutput struct{}
+
+func (diskOutput) Mkdir(dir string) error {
+	if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (diskOutput) MkdirAll(dir string) error {
+	return os.MkdirAll(dir, 0755)
+}
+
+func (diskOutput) WriteFile(filePath string, content []byte) (int64, error) {
+	return int64(len(content)), ioutil.WriteFile(filePath, content, 0644)
+}
+
+func (diskOutput) CopyFile(src, dst string) (int64, error) {
+	return copyFileContents(src, dst)
+}
+
+func (diskOutput) Chmod(filePath string, mode os.FileMode) error {
+	return os.Chmod(filePath, mode)
+}
+
+func (diskOutput) Exists(filePath string) bool {
+	_, err := os.Lstat(filePath)
+	return err == nil
+}
+
+func (diskOutput) ReadFile(filePath string) ([]byte, error) {
+	return ioutil.ReadFile(filePath)
+}
+
+// memoryFile is a file written to a memoryOutput.
+type memoryFile struct {
+	content []byte
+	mode    os.FileMode
+}
+
+// memoryOutput keeps the output in memory, keyed by clean slash separated paths.
+type memoryOutput struct {
+	files map[string]*memoryFile
+	dirs  map[string]bool
+}
+
+func newMemoryOutput() *memoryOutput {
+	return &memoryOutput{files: make(map[string]*memoryFile), dirs: make(map[string]bool)}
+}
+
+func (m *memoryOutput) Mkdir(dir string) error {
+	m.dirs[path.Clean(dir)] = true
+	return nil
+}
+
+func (m *memoryOutput) MkdirAll(dir string) error {
+	for dir = path.Clean(dir); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		m.dirs[dir] = true
+	}
+	return nil
+}
+
+func (m *memoryOutput) WriteFile(filePath string, content []byte) (int64, error) {
+	// like the file system, rewriting a file keeps its mode
+	if f, ok := m.files[path.Clean(filePath)]; ok {
+		f.content = content
+		return int64(len(content)), nil
+	}
+	m.files[path.Clean(filePath)] = &memoryFile{content: content, mode: 0644}
+	return int64(len(content)), nil
+}
+
+func (m *memoryOutput) CopyFile(src, dst string) (int64, error) {
+	content, err := ioutil.ReadFile(src)
+	if err != nil {
+		return 0, err
+	}
+	return m.WriteFile(dst, content)
+}
+
+func (m *memoryOutput) Chmod(filePath string, mode os.FileMode) error {
+	if f, ok := m.files[path.Clean(filePath)]; ok {
+		f.mode = mode
+	}
+	return nil
+}
+
+func (m *memoryOutput) Exists(filePath string) bool {
+	filePath = path.Clean(filePath)
+	_, isFile := m.files[filePath]
+	return isFile || m.dirs[filePath]
+}
+
+func (m *memoryOutput) ReadFile(filePath string) ([]byte, error) {
+	f, ok := m.files[path.Clean(filePath)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: filePath, Err: os.ErrNotExist}
+	}
+	return f.content, nil
+}
+
+// filePaths returns the paths of the files in memory in sorted order.
+func (m *memoryOutput) filePaths() []string {
+	paths := make([]string, 0, len(m.files))
+	for filePath := range m.files {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+// defaultPluginTimeout is how long spiro waits for a plugin to answer a single request.
+const defaultPluginTimeout = 10 * time.Second
+
+var pluginFunctionNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// pluginConfig declares a plugin executable, either in the user config or in a template manifest.
+type pluginConfig struct {
+	Name    string   `yaml:"name"`
+	Command string   `yaml:"command"`
+	Args    []string `yaml:"args"`
+	Timeout string   `yaml:"timeout"`
+}
+
+// pluginRequest is a single line written to the plugin's stdin. The "describe" method asks the plugin for the names of
+// the functions it provides, the "call" method invokes one of them.
+type pluginRequest struct {
+	ID       int           `json:"id"`
+	Method   string        `json:"method"`
+	Function string        `json:"function,omitempty"`
+	Args     []interface{} `json:"args,omitempty"`
+}
+
+// pluginResponse is a single line read from the plugin's stdout.
+type pluginResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// plugin is a running plugin process. Requests are serialised so that a plugin only ever handles one at a time.
+type plugin struct {
+	name      string
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	responses chan pluginResponse
+	timeout   time.Duration
+	lock      sync.Mutex
+	nextID    int
+	failed    error
+	// functions are the names of the template functions that the plugin provides.
+	functions []string
+}
+
+// startPlugin launches the plugin executable. Relative commands containing a path separator are resolved against
+// baseDir so that templates can ship their own plugins.
+func startPlugin(config pluginConfig, baseDir string) (*plugin, error) {
+	if config.Command == "" {
+		return nil, fmt.Errorf("Plugin '%s' does not declare a command", config.Name)
+	}
+	command := config.Command
+	if !filepath.IsAbs(command) && strings.ContainsRune(command, filepath.Separator) && baseDir != "" {
+		command = filepath.Join(baseDir, command)
+	}
+	name := config.Name
+	if name == "" {
+		name = filepath.Base(config.Command)
+	}
+	timeout := defaultPluginTimeout
+	if config.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(config.Timeout); err != nil {
+			return nil, fmt.Errorf("Plugin '%s' has an invalid timeout '%s': %s", name, config.Timeout, err)
+		}
+	}
+
+	p := &plugin{name: name, timeout: timeout, responses: make(chan pluginResponse, 16)}
+	p.cmd = exec.Command(command, config.Args...)
+	p.cmd.Stderr = os.Stderr
+	p.cmd.Env = os.Environ()
+	var err error
+	if p.stdin, err = p.cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("Could not start plugin '%s': %s", name, err)
+	}
+	go p.readResponses(stdout)
+	return p, nil
+}
+
+func (p *plugin) readResponses(stdout io.Reader) {
+	defer close(p.responses)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var response pluginResponse
+		if err := json.Unmarshal(line, &response); err != nil {
+			response = pluginResponse{ID: -1, Error: fmt.Sprintf("invalid response line %q: %s", line, err)}
+		}
+		p.responses <- response
+	}
+}
+
+// request sends a request and waits for the matching response. If the plugin does not answer within the timeout it is
+// killed and every later call fails.
+func (p *plugin) request(method, function string, args []interface{}) (json.RawMessage, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.failed != nil {
+		return nil, p.failed
+	}
+	p.nextID++
+	raw, err := json.Marshal(pluginRequest{ID: p.nextID, Method: method, Function: function, Args: args})
+	if err != nil {
+		return nil, fmt.Errorf("could not encode arguments for plugin '%s': %s", p.name, err)
+	}
+	if _, err := p.stdin.Write(append(raw, '\n')); err != nil {
+		p.failed = fmt.Errorf("plugin '%s' is not accepting requests: %s", p.name, err)
+		return nil, p.failed
+	}
+
+	deadline := time.After(p.timeout)
+	for {
+		select {
+		case response, ok := <-p.responses:
+			if !ok {
+				p.failed = fmt.Errorf("plugin '%s' exited unexpectedly", p.name)
+				return nil, p.failed
+			}
+			if response.ID == -1 {
+				p.failed = fmt.Errorf("plugin '%s' sent an %s", p.name, response.Error)
+				return nil, p.failed
+			}
+			if response.ID != p.nextID {
+				// a late answer to a previous request, ignore it
+				continue
+			}
+			if response.Error != "" {
+				return nil, fmt.Errorf("plugin '%s': %s", p.name, response.Error)
+			}
+			return response.Result, nil
+		case <-deadline:
+			p.failed = fmt.Errorf("plugin '%s' did not respond to '%s' within %s", p.name, method, p.timeout)
+			p.cmd.Process.Kill()
+			return nil, p.failed
+		}
+	}
+}
+
+// Describe asks the plugin which functions it provides.
+func (p *plugin) Describe() ([]string, error) {
+	raw, err := p.request("describe", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	var description struct {
+		Functions []string `json:"functions"`
+	}
+	if err := json.Unmarshal(raw, &description); err != nil {
+		return nil, fmt.Errorf("plugin '%s' returned an invalid description: %s", p.name, err)
+	}
+	for _, f := range description.Functions {
+		if !pluginFunctionNameRegexp.MatchString(f) {
+			return nil, fmt.Errorf("plugin '%s' advertised an invalid function name '%s'", p.name, f)
+		}
+	}
+	return description.Functions, nil
+}
+
+// Call invokes one of the plugin's functions. Arguments are normalized so that YAML decoded maps can be marshalled, and
+// the JSON result is decoded into the same shapes as the spec.
+func (p *plugin) Call(function string, args []interface{}) (interface{}, error) {
+	normalized := make([]interface{}, len(args))
+	for i, a := range args {
+		normalized[i] = normalize(a)
+	}
+	raw, err := p.request("call", function, normalized)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	return FromJSON(string(raw))
+}
+
+// Close asks the plugin to exit by closing its stdin, killing it if it does not exit in time.
+func (p *plugin) Close() {
+	p.stdin.Close()
+	done := make(chan struct{})
+	go func() {
+		p.cmd.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(p.timeout):
+		p.cmd.Process.Kill()
+		<-done
+	}
+}
+
+// pluginSet is the running plugins of an invocation. They are started once and their functions are registered with
+// the template factory of every spec that is rendered.
+type pluginSet struct {
+	plugins []*plugin
+	warned  map[string]bool
+}
+
+// startPlugins launches the plugins declared in the user config and in the manifests of the template layers, and asks
+// each for the functions it provides. Template plugins are resolved relative to the root of the layer that declares
+// them.
+func startPlugins(userPlugins []pluginConfig, layers []templateLayer) (*pluginSet, error) {
+	set := &pluginSet{warned: make(map[string]bool)}
+	start := func(config pluginConfig, baseDir string) error {
+		p, err := startPlugin(config, baseDir)
+		if err != nil {
+			return err
+		}
+		set.plugins = append(set.plugins, p)
+		if p.functions, err = p.Describe(); err != nil {
+			return err
+		}
+		return nil
+	}
+	for _, config := range userPlugins {
+		if err := start(config, ""); err != nil {
+			set.Close()
+			return nil, err
+		}
+	}
+	for _, layer := range layers {
+		for _, config := range layer.manifest.Plugins {
+			if err := start(config, layer.root); err != nil {
+				set.Close()
+				return nil, err
+			}
+		}
+	}
+	return set, nil
+}
+
+// register adds the functions of the plugins to the template factory. A plugin function replaces a builtin function or
+// the function of an earlier plugin with the same name, which is warned about once.
+func (s *pluginSet) register(tf *templatefactory.TemplateFactory) {
+	for _, p := range s.plugins {
+		running := p
+		for _, name := range running.functions {
+			if tf.HasTemplateFunction(name) && !s.warned[name] {
+				logger.Warn("Plugin '%s' overrides the '%s' template function", running.name, name)
+				s.warned[name] = true
+			}
+			function := name
+			tf.RegisterTemplateFunction(name, func(args ...interface{}) (interface{}, error) {
+				return running.Call(function, args)
+			})
+		}
+	}
+}
+
+// Close stops the plugins.
+func (s *pluginSet) Close() {
+	for _, p := range s.plugins {
+		p.Close()
+	}
+}
+
+// startPlugins starts the plugins for the settings unless safe mode is on, in which case a template that declares
+// plugins is refused. The returned function stops the plugins.
+func (s *renderSettings) startPlugins() (func(), error) {
+	if s.safe != nil {
+		for _, layer := range s.layers {
+			if len(layer.manifest.Plugins) > 0 {
+				return func() {}, safeModeViolation("the template manifest '%s' declares plugins, plugins can not be run", filepath.Join(layer.root, manifestFileName))
+			}
+		}
+		return func() {}, nil
+	}
+	plugins, err := startPlugins(s.config.Plugins, s.layers)
+	if err != nil {
+		return func() {}, err
+	}
+	s.plugins = plugins
+	return plugins.Close, nil
+}
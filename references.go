@@ -0,0 +1,89 @@
+package main
+
+import (
+	"text/template/parse"
+)
+
+// templateReferences describes the parts of the spec and the functions that a template refers to.
+type templateReferences struct {
+	// fields are the top level spec fields referenced as `.name` or `$.name`.
+	fields map[string]bool
+	// wholeSpec is set when the spec is used as a whole, for example `toJson .`.
+	wholeSpec bool
+	// functions are the names of the functions called by the template.
+	functions map[string]bool
+}
+
+// findTemplateReferences parses a template and collects what it refers to. Inside `range` and `with` blocks the dot is
+// no longer the spec, so only `$` references count there.
+func findTemplateReferences(text, startDelim, endDelim string) (*templateReferences, error) {
+	trees := map[string]*parse.Tree{}
+	tree := parse.New("")
+	tree.Mode = parse.SkipFuncCheck
+	if _, err := tree.Parse(text, startDelim, endDelim, trees); err != nil {
+		return nil, err
+	}
+	refs := &templateReferences{fields: make(map[string]bool), functions: make(map[string]bool)}
+	for _, t := range trees {
+		refs.walk(t.Root, true)
+	}
+	refs.walk(tree.Root, true)
+	return refs, nil
+}
+
+func (r *templateReferences) walk(node parse.Node, dotIsSpec bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, child := range n.Nodes {
+				r.walk(child, dotIsSpec)
+			}
+		}
+	case *parse.ActionNode:
+		r.walk(n.Pipe, dotIsSpec)
+	case *parse.PipeNode:
+		if n != nil {
+			for _, cmd := range n.Cmds {
+				r.walk(cmd, dotIsSpec)
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			r.walk(arg, dotIsSpec)
+		}
+	case *parse.ChainNode:
+		r.walk(n.Node, dotIsSpec)
+	case *parse.IfNode:
+		r.walk(n.Pipe, dotIsSpec)
+		r.walk(n.List, dotIsSpec)
+		r.walk(n.ElseList, dotIsSpec)
+	case *parse.RangeNode:
+		r.walk(n.Pipe, dotIsSpec)
+		r.walk(n.List, false)
+		r.walk(n.ElseList, dotIsSpec)
+	case *parse.WithNode:
+		r.walk(n.Pipe, dotIsSpec)
+		r.walk(n.List, false)
+		r.walk(n.ElseList, dotIsSpec)
+	case *parse.TemplateNode:
+		r.walk(n.Pipe, dotIsSpec)
+	case *parse.IdentifierNode:
+		r.functions[n.Ident] = true
+	case *parse.FieldNode:
+		if dotIsSpec {
+			r.fields[n.Ident[0]] = true
+		}
+	case *parse.DotNode:
+		if dotIsSpec {
+			r.wholeSpec = true
+		}
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			if len(n.Ident) > 1 {
+				r.fields[n.Ident[1]] = true
+			} else {
+				r.wholeSpec = true
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+// The limits applied by -safe unless they are changed with the -max-* and -render-timeout flags.
+const (
+	defaultSafeMaxOutputBytes = 64 << 20
+	defaultSafeMaxFiles       = 10000
+	defaultSafeMaxDepth       = 32
+	defaultSafeRenderTimeout  = 10 * time.Second
+)
+
+// unsafeFunctions fail when called in safe mode: the file functions, and secretEnv since it would let a template copy
+// any environment variable, such as a token, into its output.
+var unsafeFunctions = append([]string{"secretEnv"}, fileFunctions...)
+
+// safeLimits restricts what an untrusted template can do. In safe mode plugins and the functions that read files or the
+// environment are disabled, symlinks are refused and the size and shape of the output are bounded.
+type safeLimits struct {
+	maxOutputBytes int64
+	maxFiles       int
+	maxDepth       int
+	renderTimeout  time.Duration
+}
+
+// safeModeError is returned when a template does something that safe mode does not allow.
+type safeModeError struct {
+	message string
+}
+
+func (e *safeModeError) Error() string {
+	return "Safe mode violation: " + e.message
+}
+
+func safeModeViolation(format string, args ...interface{}) error {
+	return &safeModeError{message: fmt.Sprintf(format, args...)}
+}
+
+// apply prepares the template factory for an untrusted template: the unsafe functions fail when called and every render
+// is bounded by the timeout and output limit.
+func (l *safeLimits) apply(tf *templatefactory.TemplateFactory) {
+	for _, name := range unsafeFunctions {
+		function := name
+		tf.RegisterTemplateFunction(function, func(args ...interface{}) (interface{}, error) {
+			return nil, safeModeViolation("the '%s' function can not be used", function)
+		})
+	}
+	tf.SetLimits(l.renderTimeout, l.maxOutputBytes)
+}
+
+// limitError converts a render that broke a limit into a safe mode violation, other errors are returned as nil.
+func limitError(templateString string, err error) error {
+	var violation *safeModeError
+	switch {
+	case errors.As(err, &violation):
+		return fmt.Errorf("%s in '%s'", violation.Error(), templateString)
+	case errors.Is(err, templatefactory.ErrRenderTimeout):
+		return safeModeViolation("rendering '%s' took longer than the render timeout", templateString)
+	case errors.Is(err, templatefactory.ErrOutputLimit):
+		return safeModeViolation("rendering '%s' produced more output than the output limit", templateString)
+	}
+	return nil
+}
+
+// checkSymlink refuses template entries that are symlinks, since they could expose files outside the template tree.
+func (l *safeLimits) checkSymlink(templateString string, info os.FileInfo) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return safeModeViolation("'%s' is a symlink", templateString)
+	}
+	return nil
+}
+
+// checkBase refuses a base template that is not a directory inside the input template, since extending a local path
+// outside of it could copy any readable directory into the output and extending a git or remote source would make
+// spiro fetch it. Symlinks are followed so that they can not lead out of the template.
+func (l *safeLimits) checkBase(inputTemplate, root, source string) error {
+	manifest := filepath.Join(root, manifestFileName)
+	if isGitSource(source) || isRemoteSource(source) || archiveName(path.Base(source)) != "" {
+		return safeModeViolation("'%s' extends '%s', only directories inside the template can be extended", manifest, source)
+	}
+	outside := safeModeViolation("'%s' extends '%s' which is outside of the template", manifest, source)
+	if filepath.IsAbs(source) {
+		return outside
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(inputTemplate)
+	if err != nil {
+		return err
+	}
+	resolved, err := filepath.EvalSymlinks(baseSource(root, source))
+	if err != nil {
+		// a missing base is reported when it is loaded
+		return nil
+	}
+	if !isWithin(resolvedRoot, resolved) {
+		return outside
+	}
+	return nil
+}
+
+// checkOutputPath refuses output paths that are nested too deeply or that would be written through a symlink which
+// already exists in the output directory.
+func (l *safeLimits) checkOutputPath(templateString, outputRoot, target string) error {
+	rel, err := filepath.Rel(outputRoot, target)
+	if err != nil {
+		return err
+	}
+	if depth := strings.Count(filepath.ToSlash(rel), "/") + 1; depth > l.maxDepth {
+		return safeModeViolation("'%s' renders to '%s' which is nested %d levels deep, the limit is %d", templateString, target, depth, l.maxDepth)
+	}
+	for dir := target; isWithin(outputRoot, dir) && dir != outputRoot; dir = path.Dir(dir) {
+		if info, err := os.Lstat(dir); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return safeModeViolation("'%s' renders to '%s' which goes through the symlink '%s'", templateString, target, dir)
+		}
+	}
+	return nil
+}
+
+// countOutput adds a file to the running totals of the render and refuses it when a limit is exceeded.
+func (l *safeLimits) countOutput(p *processor, templateString string, size int64) error {
+	p.outputFiles++
+	p.outputBytes += size
+	if p.outputFiles > l.maxFiles {
+		return safeModeViolation("'%s' is more than the limit of %d output files", templateString, l.maxFiles)
+	}
+	if p.outputBytes > l.maxOutputBytes {
+		return safeModeViolation("'%s' takes the output over the limit of %d bytes", templateString, l.maxOutputBytes)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SpecialSecretsKey is the spec key listing the paths of spec values that are secret, such as `db.password`.
+const SpecialSecretsKey = "_spiro_secrets_"
+
+// redactedPlaceholder replaces secret values in everything that spiro prints.
+const redactedPlaceholder = "[redacted]"
+
+// redactor keeps track of secret values so that they can be removed from log events and error messages.
+type redactor struct {
+	lock     sync.Mutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}
+
+// secrets is the process wide redactor, it is used by the event logger.
+var secrets = &redactor{values: make(map[string]bool)}
+
+// Add marks a value as secret. Lists and maps mark each of the values they contain.
+func (r *redactor) Add(value interface{}) {
+	v := indirect(reflect.ValueOf(value))
+	if !v.IsValid() {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			r.Add(v.Index(i).Interface())
+		}
+		return
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			r.Add(v.MapIndex(key).Interface())
+		}
+		return
+	}
+	text := fmt.Sprint(v.Interface())
+	if text == "" {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.values[text] {
+		return
+	}
+	r.values[text] = true
+	// longer values go first so that a secret containing another secret is redacted as a whole
+	ordered := make([]string, 0, len(r.values))
+	for value := range r.values {
+		ordered = append(ordered, value)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if len(ordered[i]) != len(ordered[j]) {
+			return len(ordered[i]) > len(ordered[j])
+		}
+		return ordered[i] < ordered[j]
+	})
+	pairs := make([]string, 0, 2*len(ordered))
+	for _, value := range ordered {
+		pairs = append(pairs, value, redactedPlaceholder)
+	}
+	r.replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact replaces every secret value in the text.
+func (r *redactor) Redact(text string) string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.replacer == nil || text == "" {
+		return text
+	}
+	return r.replacer.Replace(text)
+}
+
+// registerSpecSecrets marks the spec values listed under the secrets key as secret.
+func registerSpecSecrets(spec map[string]interface{}) error {
+	raw, ok := spec[SpecialSecretsKey]
+	if !ok || raw == nil {
+		return nil
+	}
+	paths, err := toList(raw)
+	if err != nil {
+		return fmt.Errorf("'%s' must be a list of spec paths", SpecialSecretsKey)
+	}
+	for _, item := range paths {
+		path, ok := item.(string)
+		if !ok {
+			return fmt.Errorf("'%s' must be a list of spec paths", SpecialSecretsKey)
+		}
+		value, found := lookupPath(spec, path)
+		if !found {
+			return fmt.Errorf("The secret '%s' listed in '%s' is not in the spec", path, SpecialSecretsKey)
+		}
+		secrets.Add(value)
+	}
+	return nil
+}
+
+// SecretEnv returns the value of an environment variable and marks it as secret.
+func SecretEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("the environment variable '%s' is not set", name)
+	}
+	secrets.Add(value)
+	return value, nil
+}
+
+// secureRemove overwrites a file with zeros before removing it so that secrets do not linger on disk.
+func secureRemove(path string) error {
+	if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+		if info, err := f.Stat(); err == nil {
+			f.Write(make([]byte, info.Size()))
+			f.Sync()
+		}
+		f.Close()
+	}
+	return os.Remove(path)
+}
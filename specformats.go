@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The spec formats understood by spiro.
+const (
+	specFormatYAML = "yaml"
+	specFormatJSON = "json"
+	specFormatTOML = "toml"
+	specFormatEnv  = "env"
+	specFormatHCL  = "hcl"
+	specFormatCSV  = "csv"
+)
+
+// envNestingSeparator splits dotenv keys into nested maps: DB__HOST=x becomes {DB: {HOST: x}}.
+const envNestingSeparator = "__"
+
+var specFormatsByExtension = map[string]string{
+	".yaml":  specFormatYAML,
+	".yml":   specFormatYAML,
+	".json":  specFormatJSON,
+	".jsonc": specFormatJSON,
+	".toml":  specFormatTOML,
+	".env":   specFormatEnv,
+	".hcl":   specFormatHCL,
+	".csv":   specFormatCSV,
+}
+
+// detectSpecFormat returns the format of the spec file, either as given by the -spec-format flag or from the file
+// extension. Unknown extensions and stdin are treated as YAML, which also accepts plain JSON.
+func detectSpecFormat(specFile, formatFlag string) (string, error) {
+	if formatFlag != "" {
+		switch formatFlag {
+		case specFormatYAML, specFormatJSON, specFormatTOML, specFormatEnv, specFormatHCL, specFormatCSV:
+			return formatFlag, nil
+		}
+		return "", fmt.Errorf("Unknown spec format '%s', expected one of yaml, json, toml, env, hcl or csv", formatFlag)
+	}
+	if specFile == "-" {
+		return specFormatYAML, nil
+	}
+	if format, ok := specFormatsByExtension[strings.ToLower(filepath.Ext(specFile))]; ok {
+		return format, nil
+	}
+	return specFormatYAML, nil
+}
+
+// decodeSpec parses the raw spec content in the given format into the map passed to the templates.
+func decodeSpec(content []byte, format string) (map[string]interface{}, error) {
+	var spec map[string]interface{}
+	var err error
+	switch format {
+	case specFormatJSON:
+		spec, err = decodeLenientJSON(content)
+	case specFormatTOML:
+		spec, err = decodeTOML(content)
+	case specFormatEnv:
+		spec, err = decodeDotEnv(content)
+	case specFormatHCL:
+		spec, err = decodeHCL(content)
+	case specFormatCSV:
+		return nil, fmt.Errorf("CSV spec files contain one spec per row and can only be used with -batch")
+	default:
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		err = dec.Decode(&spec)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s spec file: %s", format, err.Error())
+	}
+	if spec == nil {
+		spec = make(map[string]interface{})
+	}
+	return spec, nil
+}
+
+// stripJSONExtensions blanks out // and /* */ comments and trailing commas so that the result can be parsed by the
+// standard JSON decoder. Removed characters are replaced with spaces so that error offsets still line up.
+func stripJSONExtensions(content []byte) []byte {
+	out := append([]byte{}, content...)
+	inString := false
+	lastComma := -1
+	for i := 0; i < len(out); i++ {
+		c := out[i]
+		if inString {
+			if c == '\\' {
+				i++
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch {
+		case c == '"':
+			inString = true
+			lastComma = -1
+		case c == '/' && i+1 < len(out) && out[i+1] == '/':
+			for ; i < len(out) && out[i] != '\n'; i++ {
+				out[i] = ' '
+			}
+		case c == '/' && i+1 < len(out) && out[i+1] == '*':
+			for ; i < len(out) && !(out[i] == '*' && i+1 < len(out) && out[i+1] == '/'); i++ {
+				if out[i] != '\n' {
+					out[i] = ' '
+				}
+			}
+			if i < len(out) {
+				out[i] = ' '
+				out[i+1] = ' '
+				i++
+			}
+		case c == ',':
+			lastComma = i
+		case c == '}' || c == ']':
+			if lastComma >= 0 {
+				out[lastComma] = ' '
+			}
+			lastComma = -1
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		default:
+			lastComma = -1
+		}
+	}
+	return out
+}
+
+// decodeLenientJSONValue parses any JSON value that may contain comments and trailing commas.
+func decodeLenientJSONValue(content []byte, into interface{}) error {
+	clean := stripJSONExtensions(content)
+	dec := json.NewDecoder(bytes.NewReader(clean))
+	dec.UseNumber()
+	if err := dec.Decode(into); err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			line := bytes.Count(clean[:syntaxErr.Offset], []byte("\n")) + 1
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// decodeLenientJSON parses a JSON object that may contain comments and trailing commas.
+func decodeLenientJSON(content []byte) (map[string]interface{}, error) {
+	var spec map[string]interface{}
+	if err := decodeLenientJSONValue(content, &spec); err != nil {
+		return nil, err
+	}
+	normalizeJSONNumbers(spec)
+	return spec, nil
+}
+
+// unquoteEnvValue handles the quoting rules of dotenv values: double quoted values support escapes, single quoted
+// values are literal and unquoted values end at an inline comment.
+func unquoteEnvValue(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", nil
+	}
+	switch raw[0] {
+	case '"':
+		end := -1
+		for i := 1; i < len(raw); i++ {
+			if raw[i] == '\\' {
+				i++
+			} else if raw[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated double quoted value")
+		}
+		replacer := strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\"`, `"`, `\\`, `\`, `\$`, `$`)
+		return replacer.Replace(raw[1:end]), nil
+	case '\'':
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quoted value")
+		}
+		return raw[1 : end+1], nil
+	}
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = raw[:i]
+	}
+	return strings.TrimSpace(raw), nil
+}
+
+// decodeDotEnv parses a flat KEY=VALUE file. Keys containing "__" are split into nested maps.
+func decodeDotEnv(content []byte) (map[string]interface{}, error) {
+	spec := make(map[string]interface{})
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNumber)
+		}
+		key := strings.TrimSpace(line[:eq])
+		value, err := unquoteEnvValue(line[eq+1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+
+		parts := strings.Split(key, envNestingSeparator)
+		current := spec
+		for _, part := range parts[:len(parts)-1] {
+			switch existing := current[part].(type) {
+			case nil:
+				child := make(map[string]interface{})
+				current[part] = child
+				current = child
+			case map[string]interface{}:
+				current = existing
+			default:
+				return nil, fmt.Errorf("line %d: '%s' is already set to a value and cannot also contain nested keys", lineNumber, part)
+			}
+		}
+		last := parts[len(parts)-1]
+		if _, isMap := current[last].(map[string]interface{}); isMap {
+			return nil, fmt.Errorf("line %d: '%s' already contains nested keys and cannot also be set to a value", lineNumber, key)
+		}
+		current[last] = value
+	}
+	return spec, scanner.Err()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetectSpecFormat(t *testing.T) {
+	cases := []struct {
+		file, flag, want string
+	}{
+		{"spec.yaml", "", specFormatYAML},
+		{"spec.YML", "", specFormatYAML},
+		{"spec.json", "", specFormatJSON},
+		{"spec.jsonc", "", specFormatJSON},
+		{"spec.json5", "", specFormatYAML},
+		{"spec.toml", "", specFormatTOML},
+		{".env", "", specFormatEnv},
+		{"spec.hcl", "", specFormatHCL},
+		{"rows.csv", "", specFormatCSV},
+		{"spec.txt", "", specFormatYAML},
+		{"-", "", specFormatYAML},
+		{"spec.yaml", "toml", specFormatTOML},
+		{"-", "json", specFormatJSON},
+	}
+	for _, c := range cases {
+		got, err := detectSpecFormat(c.file, c.flag)
+		if err != nil {
+			t.Errorf("%s with %q: unexpected error: %s", c.file, c.flag, err)
+		} else if got != c.want {
+			t.Errorf("%s with %q: got %s, want %s", c.file, c.flag, got, c.want)
+		}
+	}
+	if _, err := detectSpecFormat("spec.yaml", "json5"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestDecodeLenientJSON(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  map[string]interface{}
+	}{
+		{"plain", `{"a": 1, "b": [1.5, "x"], "c": {"d": null}}`, map[string]interface{}{
+			"a": 1, "b": []interface{}{1.5, "x"}, "c": map[string]interface{}{"d": nil},
+		}},
+		{"line comments", "{\n  // a comment\n  \"a\": 1 // trailing\n}", map[string]interface{}{"a": 1}},
+		{"block comments", "{ /* one\ntwo */ \"a\": /* inline */ 1 }", map[string]interface{}{"a": 1}},
+		{"trailing commas", "{\"a\": [1, 2,], \"b\": {\"c\": 3,},}", map[string]interface{}{
+			"a": []interface{}{1, 2}, "b": map[string]interface{}{"c": 3},
+		}},
+		{"comment markers in strings", `{"url": "http://x/*y*/", "s": "a, }"}`, map[string]interface{}{
+			"url": "http://x/*y*/", "s": "a, }",
+		}},
+		{"escaped quotes in strings", `{"a": "say \"hi\" // not a comment"}`, map[string]interface{}{
+			"a": `say "hi" // not a comment`,
+		}},
+		{"large integers", `{"a": 9007199254740993}`, map[string]interface{}{"a": 9007199254740993}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeLenientJSON([]byte(c.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeLenientJSONErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"unquoted keys", "{a: 1}", "line 1"},
+		{"single quotes", "{'a': 1}", "line 1"},
+		{"error line", "{\n  \"a\": 1,\n  \"b\" 2\n}", "line 3"},
+		{"not an object", "[1, 2]", "cannot unmarshal array"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := decodeLenientJSON([]byte(c.input))
+			if err == nil {
+				t.Fatalf("expected an error containing %q", c.want)
+			}
+			if !strings.Contains(err.Error(), c.want) {
+				t.Errorf("got error %q, want it to contain %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeDotEnv(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  map[string]interface{}
+	}{
+		{"plain", "A=1\nB = two words\n", map[string]interface{}{"A": "1", "B": "two words"}},
+		{"comments and blank lines", "# comment\n\nA=1 # inline\nB=x#y\n", map[string]interface{}{"A": "1", "B": "x#y"}},
+		{"export", "export A=1\n", map[string]interface{}{"A": "1"}},
+		{"double quotes", `A="line\nnext \"q\" \$HOME # kept"` + "\n", map[string]interface{}{"A": "line\nnext \"q\" $HOME # kept"}},
+		{"single quotes", `A='raw \n $HOME'` + "\n", map[string]interface{}{"A": `raw \n $HOME`}},
+		{"empty value", "A=\n", map[string]interface{}{"A": ""}},
+		{"nesting", "DB__HOST=x\nDB__PORT=5432\nDB__AUTH__USER=u\n", map[string]interface{}{
+			"DB": map[string]interface{}{"HOST": "x", "PORT": "5432", "AUTH": map[string]interface{}{"USER": "u"}},
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeDotEnv([]byte(c.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeDotEnvErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"missing equals", "A=1\nB\n", "line 2: expected KEY=VALUE"},
+		{"missing key", "=1\n", "line 1: expected KEY=VALUE"},
+		{"unterminated double quote", "A=\"x\n", "unterminated double quoted value"},
+		{"unterminated single quote", "A='x\n", "unterminated single quoted value"},
+		{"value then nested", "DB=x\nDB__HOST=y\n", "'DB' is already set to a value"},
+		{"nested then value", "DB__HOST=y\nDB=x\n", "'DB' already contains nested keys"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := decodeDotEnv([]byte(c.input))
+			if err == nil {
+				t.Fatalf("expected an error containing %q", c.want)
+			}
+			if !strings.Contains(err.Error(), c.want) {
+				t.Errorf("got error %q, want it to contain %q", err, c.want)
+			}
+		})
+	}
+}
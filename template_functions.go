@@ -1,51 +1,151 @@
 package main
 
 import (
-	"encoding/json"
+	"fmt"
 	"html/template"
-	"reflect"
 	"regexp"
 	"strings"
+	"unicode"
 )
 
-func Jsonify(in map[interface{}]interface{}) string {
-	working := make(map[string]interface{})
-	s := reflect.ValueOf(in)
-	for _, k := range s.MapKeys() {
-		working[k.Interface().(string)] = s.MapIndex(k).Interface()
+func Unescape(in interface{}) interface{} {
+	return template.HTML(fmt.Sprint(in))
+}
+
+func StringReplace(subj string, old string, new string) string {
+	return strings.Replace(subj, old, new, -1)
+}
+
+// splitWords breaks a string into its component words for the case conversion functions. Words are separated by any
+// non alphanumeric characters, by lower to upper case transitions ("fooBar"), by the end of an acronym ("HTTPServer")
+// and by transitions between letters and digits ("Server2"). A lower case "s" that ends a word after an acronym is its
+// plural and stays part of it ("userIDs").
+func splitWords(in string) []string {
+	var words []string
+	var current []rune
+	runes := []rune(in)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(current) > 0 {
+				words = append(words, string(current))
+				current = nil
+			}
+			continue
+		}
+		if len(current) > 0 {
+			prev := current[len(current)-1]
+			if (unicode.IsLower(prev) && unicode.IsUpper(r)) ||
+				(unicode.IsLetter(prev) && unicode.IsDigit(r)) ||
+				(unicode.IsDigit(prev) && unicode.IsLetter(r)) ||
+				(unicode.IsUpper(prev) && unicode.IsUpper(r) && i+1 < len(runes) && unicode.IsLower(runes[i+1]) && !isAcronymPlural(runes, i+1)) {
+				words = append(words, string(current))
+				current = nil
+			}
+		}
+		current = append(current, r)
 	}
-	sb, err := json.Marshal(working)
-	if err != nil {
-		panic(err)
+	if len(current) > 0 {
+		words = append(words, string(current))
 	}
-	return string(sb)
+	return words
+}
+
+// isAcronymPlural reports whether the rune at i is an "s" that ends the word, which makes it the plural of the acronym
+// in front of it rather than the start of a new word.
+func isAcronymPlural(runes []rune, i int) bool {
+	return runes[i] == 's' && (i+1 == len(runes) || !unicode.IsLower(runes[i+1]))
 }
 
-func JsonifyIndent(in map[interface{}]interface{}) string {
-	working := make(map[string]interface{})
-	s := reflect.ValueOf(in)
-	for _, k := range s.MapKeys() {
-		working[k.Interface().(string)] = s.MapIndex(k).Interface()
+func capitalise(word string) string {
+	runes := []rune(strings.ToLower(word))
+	if len(runes) > 0 {
+		runes[0] = unicode.ToUpper(runes[0])
 	}
-	sb, err := json.MarshalIndent(working, "", "    ")
-	if err != nil {
-		panic(err)
+	return string(runes)
+}
+
+func joinWords(in string, sep string, convert func(string) string) string {
+	words := splitWords(in)
+	for i, w := range words {
+		words[i] = convert(w)
 	}
-	return string(sb)
+	return strings.Join(words, sep)
 }
 
-func Unescape(in string) interface{} {
-	return template.HTML(in)
+// SnakeCase converts "HTTPServer2" to "http_server_2".
+func SnakeCase(in string) string {
+	return joinWords(in, "_", strings.ToLower)
 }
 
-func StringReplace(subj string, old string, new string) string {
-	return strings.Replace(subj, old, new, -1)
+// KebabCase converts "HTTPServer2" to "http-server-2".
+func KebabCase(in string) string {
+	return joinWords(in, "-", strings.ToLower)
+}
+
+// ScreamingSnakeCase converts "HTTPServer2" to "HTTP_SERVER_2".
+func ScreamingSnakeCase(in string) string {
+	return joinWords(in, "_", strings.ToUpper)
+}
+
+// DotCase converts "HTTPServer2" to "http.server.2".
+func DotCase(in string) string {
+	return joinWords(in, ".", strings.ToLower)
+}
+
+// PascalCase converts "http server 2" to "HttpServer2".
+func PascalCase(in string) string {
+	return joinWords(in, "", capitalise)
+}
+
+// CamelCase converts "HTTP server 2" to "httpServer2".
+func CamelCase(in string) string {
+	words := splitWords(in)
+	for i, w := range words {
+		if i == 0 {
+			words[i] = strings.ToLower(w)
+		} else {
+			words[i] = capitalise(w)
+		}
+	}
+	return strings.Join(words, "")
+}
+
+// slugReplacements folds the common accented latin characters to their ascii equivalents.
+var slugReplacements = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "æ", "ae", "ç", "c",
+	"è", "e", "é", "e", "ê", "e", "ë", "e", "ì", "i", "í", "i", "î", "i", "ï", "i",
+	"ñ", "n", "ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "œ", "oe",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ý", "y", "ÿ", "y", "ß", "ss",
+)
+
+// Slug converts a string to a lower case, hyphen separated string that is safe to use in file names and URLs. Any
+// characters outside of a-z and 0-9 that can not be folded to ascii are dropped. "Héllo, World!" becomes "hello-world".
+func Slug(in string) string {
+	var parts []string
+	for _, w := range splitWords(in) {
+		w = strings.Map(func(r rune) rune {
+			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+				return r
+			}
+			return -1
+		}, slugReplacements.Replace(strings.ToLower(w)))
+		if w != "" {
+			parts = append(parts, w)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// Initials returns the upper cased first character of each word: "HTTPServer2" becomes "HS2".
+func Initials(in string) string {
+	var out []rune
+	for _, w := range splitWords(in) {
+		out = append(out, unicode.ToUpper([]rune(w)[0]))
+	}
+	return string(out)
 }
 
 func RegexReplace(subj string, pattern string, repl string) string {
 	re := regexp.MustCompile(pattern)
 	return re.ReplaceAllString(subj, repl)
 }
-func Add(a int, b int) int {
-	return a + b
-}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// indirect unwraps pointers and interfaces. The root spec is passed to templates as a *map[string]interface{} so the
+// collection functions must see through it.
+func indirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// isEmpty reports whether a value is nil, false, zero or an empty string or collection.
+func isEmpty(in interface{}) bool {
+	v := indirect(reflect.ValueOf(in))
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	}
+	return false
+}
+
+// toList converts any slice or array into a []interface{}. A nil value is treated as an empty list.
+func toList(in interface{}) ([]interface{}, error) {
+	v := indirect(reflect.ValueOf(in))
+	if !v.IsValid() {
+		return []interface{}{}, nil
+	}
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, fmt.Errorf("expected a list but got %T", in)
+	}
+	out := make([]interface{}, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		out[i] = v.Index(i).Interface()
+	}
+	return out, nil
+}
+
+// lookupKey finds a key in either of the map shapes produced by yaml.v2: map[string]interface{} at the top level and
+// map[interface{}]interface{} for nested maps. Non string keys are matched by their printed form.
+func lookupKey(container interface{}, key string) (interface{}, bool) {
+	v := indirect(reflect.ValueOf(container))
+	if !v.IsValid() || v.Kind() != reflect.Map {
+		return nil, false
+	}
+	if v.Type().Key().Kind() == reflect.String {
+		item := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+		if !item.IsValid() {
+			return nil, false
+		}
+		return item.Interface(), true
+	}
+	for _, k := range v.MapKeys() {
+		if fmt.Sprint(k.Interface()) == key {
+			return v.MapIndex(k).Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// lookupPath finds a key in a map, falling back to treating the key as a dot separated path into nested maps.
+func lookupPath(container interface{}, path string) (interface{}, bool) {
+	if item, ok := lookupKey(container, path); ok {
+		return item, true
+	}
+	if !strings.Contains(path, ".") {
+		return nil, false
+	}
+	current := container
+	for _, part := range strings.Split(path, ".") {
+		item, ok := lookupKey(current, part)
+		if !ok {
+			return nil, false
+		}
+		current = item
+	}
+	return current, true
+}
+
+// sortedKeys returns the keys of a map as sorted strings.
+func sortedKeys(in interface{}) ([]string, error) {
+	v := indirect(reflect.ValueOf(in))
+	if !v.IsValid() {
+		return []string{}, nil
+	}
+	if v.Kind() != reflect.Map {
+		return nil, fmt.Errorf("expected a map but got %T", in)
+	}
+	out := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		out = append(out, fmt.Sprint(k.Interface()))
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Default returns the given value unless it is empty, in which case the default is returned. It can be called as
+// `default "x" .value`, piped as `.value | default "x"` or, to probe a key that may not exist without triggering a
+// missing key error, as `default "x" . "key"` where the key may be a dot separated path.
+func Default(def interface{}, given ...interface{}) (interface{}, error) {
+	switch len(given) {
+	case 0:
+		return def, nil
+	case 1:
+		if isEmpty(given[0]) {
+			return def, nil
+		}
+		return given[0], nil
+	case 2:
+		key, ok := given[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("the key to look up must be a string but got %T", given[1])
+		}
+		if item, ok := lookupPath(given[0], key); ok && !isEmpty(item) {
+			return item, nil
+		}
+		return def, nil
+	}
+	return nil, fmt.Errorf("default accepts at most 3 arguments")
+}
+
+// Coalesce returns the first non empty value.
+func Coalesce(values ...interface{}) interface{} {
+	for _, v := range values {
+		if !isEmpty(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// Ternary returns the first value if the condition is not empty, otherwise the second. The condition is last so that it
+// can be piped: `.enabled | ternary "on" "off"`.
+func Ternary(ifTrue, ifFalse, condition interface{}) interface{} {
+	if !isEmpty(condition) {
+		return ifTrue
+	}
+	return ifFalse
+}
+
+// Dict builds a map from a list of key value pairs: `dict "name" .name "port" 80`.
+func Dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict requires an even number of arguments but got %d", len(pairs))
+	}
+	out := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		out[fmt.Sprint(pairs[i])] = pairs[i+1]
+	}
+	return out, nil
+}
+
+// List builds a list from the given arguments.
+func List(items ...interface{}) []interface{} {
+	return append([]interface{}{}, items...)
+}
+
+// Keys returns the sorted keys of a map.
+func Keys(in interface{}) ([]string, error) {
+	return sortedKeys(in)
+}
+
+// Values returns the values of a map ordered by their keys.
+func Values(in interface{}) ([]interface{}, error) {
+	keys, err := sortedKeys(in)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(keys))
+	for i, k := range keys {
+		out[i], _ = lookupKey(in, k)
+	}
+	return out, nil
+}
+
+// HasKey reports whether the map contains the key, which may be a dot separated path. Unlike `.key` this does not
+// trigger a missing key error.
+func HasKey(container interface{}, key string) bool {
+	_, ok := lookupPath(container, key)
+	return ok
+}
+
+// SortAlpha returns the items of a list as strings sorted alphabetically.
+func SortAlpha(in interface{}) ([]string, error) {
+	items, err := toList(in)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = fmt.Sprint(item)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Uniq returns the items of a list with duplicates removed, keeping the first occurrence of each item.
+func Uniq(in interface{}) ([]interface{}, error) {
+	items, err := toList(in)
+	if err != nil {
+		return nil, err
+	}
+	out := []interface{}{}
+	for _, item := range items {
+		seen := false
+		for _, existing := range out {
+			if reflect.DeepEqual(item, existing) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			out = append(out, item)
+		}
+	}
+	return out, nil
+}
+
+// Pluck returns the value of the key from each map in the list that contains it: `pluck "name" .services`.
+func Pluck(key string, in interface{}) ([]interface{}, error) {
+	items, err := toList(in)
+	if err != nil {
+		return nil, err
+	}
+	out := []interface{}{}
+	for _, item := range items {
+		if v, ok := lookupPath(item, key); ok {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+// First returns the first item of a list or nil if it is empty.
+func First(in interface{}) (interface{}, error) {
+	items, err := toList(in)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[0], nil
+}
+
+// Last returns the last item of a list or nil if it is empty.
+func Last(in interface{}) (interface{}, error) {
+	items, err := toList(in)
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+	return items[len(items)-1], nil
+}
+
+// Join joins the items of a list into a string: `.tags | join ", "`.
+func Join(sep string, in interface{}) (string, error) {
+	items, err := toList(in)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = fmt.Sprint(item)
+	}
+	return strings.Join(parts, sep), nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// skipFileError is raised by skipFile to discard the file, or directory, whose template called it.
+type skipFileError struct {
+	reason string
+}
+
+func (e *skipFileError) Error() string {
+	return "skipFile was called"
+}
+
+// templateFailure is raised by fail and required to abort rendering with a message from the template author.
+type templateFailure struct {
+	message string
+}
+
+func (e *templateFailure) Error() string {
+	return e.message
+}
+
+// SkipFile discards the output of the current file as if its name had rendered empty. An optional reason is logged.
+func SkipFile(reason ...string) (string, error) {
+	return "", &skipFileError{reason: strings.Join(reason, " ")}
+}
+
+// Fail aborts rendering with the given message.
+func Fail(message string) (string, error) {
+	return "", &templateFailure{message: message}
+}
+
+// Required returns the value, or aborts rendering with the message when it is empty. Like default, the value can also
+// be given as a map and a dot separated path so that missing keys can be probed: `required "msg" . "db.host"`.
+func Required(message string, value interface{}, keyPath ...string) (interface{}, error) {
+	if len(keyPath) > 1 {
+		return nil, fmt.Errorf("expected at most one key path but got %d", len(keyPath))
+	}
+	if len(keyPath) == 1 {
+		value, _ = lookupPath(value, keyPath[0])
+	}
+	if isEmpty(value) {
+		return nil, &templateFailure{message: message}
+	}
+	return value, nil
+}
+
+// renderError interprets an error from rendering part of a template. When the template called skipFile the skip is
+// logged and nil is returned. When it called fail, or required with an empty value, the author's message is returned.
+// Safe mode violations are reported as such. Any other error is described by the format, which receives the template path and wraps the error.
+func renderError(templateString string, err error, format string) error {
+	var skip *skipFileError
+	if errors.As(err, &skip) {
+		if skip.reason != "" {
+			logger.Skip(templateString, "it called skipFile: "+skip.reason)
+		} else {
+			logger.Skip(templateString, "it called skipFile")
+		}
+		return nil
+	}
+	var failure *templateFailure
+	if errors.As(err, &failure) {
+		return fmt.Errorf("Template '%s' failed: %s", templateString, failure.message)
+	}
+	if violation := limitError(templateString, err); violation != nil {
+		return violation
+	}
+	return fmt.Errorf(format, templateString, err)
+}
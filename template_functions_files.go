@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+// maxIncludeDepth stops templates that include themselves from recursing forever.
+const maxIncludeDepth = 32
+
+// templateFiles gives templates read access to the other files in the template tree. All paths are resolved relative
+// to the template root and may not escape it. When the template extends base templates, a path that does not exist in
+// the template is looked up in each base in turn.
+type templateFiles struct {
+	roots []string
+	tf    *templatefactory.TemplateFactory
+	depth int
+}
+
+// templateRoot returns the directory that file functions resolve paths against: the input template itself if it is a
+// directory, or the directory containing it if it is a single file.
+func templateRoot(inputTemplate string, isDir bool) string {
+	if isDir {
+		return inputTemplate
+	}
+	return filepath.Dir(inputTemplate)
+}
+
+// isWithin reports whether the path is the same as, or inside, the root directory. Both must be clean.
+func isWithin(root, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// locate returns the first root that contains the template relative path, or the last root when none do.
+func (t *templateFiles) locate(name string) string {
+	for _, root := range t.roots[:len(t.roots)-1] {
+		if _, err := os.Lstat(filepath.Join(root, filepath.FromSlash(name))); err == nil {
+			return root
+		}
+	}
+	return t.roots[len(t.roots)-1]
+}
+
+// resolve converts a template relative path into a real path in the first root that contains it.
+func (t *templateFiles) resolve(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("'%s' must be relative to the template root", name)
+	}
+	return t.resolveIn(t.locate(name), name)
+}
+
+// resolveIn converts a template relative path into a real path, rejecting any path that escapes the root either
+// lexically or through a symlink.
+func (t *templateFiles) resolveIn(templateRoot, name string) (string, error) {
+	root, err := filepath.Abs(templateRoot)
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(root, filepath.FromSlash(name))
+	if !isWithin(root, target) {
+		return "", fmt.Errorf("'%s' is outside of the template root", name)
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	realTarget, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return "", fmt.Errorf("could not read '%s': %s", name, err)
+	}
+	if !isWithin(realRoot, realTarget) {
+		return "", fmt.Errorf("'%s' is outside of the template root", name)
+	}
+	return realTarget, nil
+}
+
+func (t *templateFiles) read(name string) ([]byte, error) {
+	target, err := t.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(target)
+	if err != nil {
+		return nil, fmt.Errorf("could not read '%s': %s", name, err)
+	}
+	return content, nil
+}
+
+// ReadFile returns the raw contents of a file in the template tree. They are not escaped by the template engine so the
+// file is written exactly as it is.
+func (t *templateFiles) ReadFile(name string) (template.HTML, error) {
+	content, err := t.read(name)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(content), nil
+}
+
+// IncludeTemplate renders another file from the template tree with the current spec. The result has already been
+// escaped by the template engine so it is not escaped a second time.
+func (t *templateFiles) IncludeTemplate(name string) (template.HTML, error) {
+	if t.depth >= maxIncludeDepth {
+		return "", fmt.Errorf("includes are nested more than %d levels deep", maxIncludeDepth)
+	}
+	content, err := t.read(name)
+	if err != nil {
+		return "", err
+	}
+	t.depth++
+	defer func() { t.depth-- }()
+	src := templatefactory.Source{Path: filepath.Join(t.locate(name), filepath.FromSlash(name)), Kind: templateKindContent}
+	output, err := t.tf.Render(src, string(content))
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(output), nil
+}
+
+// Data parses a csv, json or yaml file from the template tree. CSV files must have a header row and are returned as a
+// list of maps keyed by the header.
+func (t *templateFiles) Data(name string) (interface{}, error) {
+	content, err := t.read(name)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return parseCSVRecords(content)
+	case ".json":
+		return FromJSON(string(content))
+	case ".yaml", ".yml":
+		return FromYAML(string(content))
+	}
+	return nil, fmt.Errorf("cannot load data from '%s': expected a .csv, .json, .yaml or .yml file", name)
+}
+
+// parseCSVRecords reads a csv document with a header row into a list of maps.
+func parseCSVRecords(content []byte) ([]interface{}, error) {
+	rows, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not parse csv: %s", err)
+	}
+	out := []interface{}{}
+	if len(rows) == 0 {
+		return out, nil
+	}
+	header := rows[0]
+	for _, row := range rows[1:] {
+		record := make(map[string]interface{}, len(header))
+		for i, column := range header {
+			if i < len(row) {
+				record[column] = row[i]
+			} else {
+				record[column] = ""
+			}
+		}
+		out = append(out, record)
+	}
+	return out, nil
+}
+
+// registerFileFunctions adds the functions that read from the template tree. Paths are looked up in each root in turn.
+func registerFileFunctions(tf *templatefactory.TemplateFactory, roots []string) {
+	files := &templateFiles{roots: roots, tf: tf}
+	tf.RegisterTemplateFunction("readFile", files.ReadFile)
+	tf.RegisterTemplateFunction("includeTemplate", files.IncludeTemplate)
+	tf.RegisterTemplateFunction("data", files.Data)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxSequenceLength protects against templates accidentally generating enormous sequences.
+const maxSequenceLength = 1000000
+
+// number is a value coerced from one of the many numeric shapes that can come out of a spec file. yaml.v2 decodes
+// integers as int, large ones as int64 or uint64 and decimals as float64, and values are often quoted as strings.
+type number struct {
+	i     int64
+	f     float64
+	isInt bool
+}
+
+func toNumber(in interface{}) (number, error) {
+	v := reflect.ValueOf(in)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number{i: v.Int(), f: float64(v.Int()), isInt: true}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return number{f: float64(v.Uint())}, nil
+		}
+		return number{i: int64(v.Uint()), f: float64(v.Uint()), isInt: true}, nil
+	case reflect.Float32, reflect.Float64:
+		return number{f: v.Float()}, nil
+	case reflect.String:
+		s := strings.TrimSpace(v.String())
+		if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return number{i: i, f: float64(i), isInt: true}, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return number{f: f}, nil
+		}
+		return number{}, fmt.Errorf("'%s' is not a number", v.String())
+	}
+	return number{}, fmt.Errorf("%v (%T) is not a number", in, in)
+}
+
+func toNumbers(in []interface{}) ([]number, bool, error) {
+	out := make([]number, len(in))
+	allInts := true
+	for i, v := range in {
+		n, err := toNumber(v)
+		if err != nil {
+			return nil, false, err
+		}
+		out[i] = n
+		allInts = allInts && n.isInt
+	}
+	return out, allInts, nil
+}
+
+// numberResult returns ints as int so that they compare naturally with literals in templates (eq (add 1 2) 3).
+func numberResult(i int64, f float64, isInt bool) interface{} {
+	if isInt {
+		return int(i)
+	}
+	return f
+}
+
+// integerOverflow reports an integer result that does not fit in 64 bits rather than letting it wrap around.
+func integerOverflow(a int64, op string, b int64) error {
+	return fmt.Errorf("integer overflow in %d %s %d, use float on the inputs for a floating point result", a, op, b)
+}
+
+// floatToInt converts a whole float to an integer, reporting values that do not fit in 64 bits rather than letting the
+// conversion produce an arbitrary result.
+func floatToInt(name string, f float64) (int64, error) {
+	// float64(math.MaxInt64) rounds up to 2^63, which is already out of range
+	if math.IsNaN(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, fmt.Errorf("%s of %v does not fit in a 64 bit integer", name, f)
+	}
+	return int64(f), nil
+}
+
+func reduceNumbers(name string, values []interface{}, ints func(a, b int64) (int64, error), floats func(a, b float64) (float64, error)) (interface{}, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s requires at least 1 argument", name)
+	}
+	nums, allInts, err := toNumbers(values)
+	if err != nil {
+		return nil, err
+	}
+	if allInts {
+		acc := nums[0].i
+		for _, n := range nums[1:] {
+			if acc, err = ints(acc, n.i); err != nil {
+				return nil, err
+			}
+		}
+		return numberResult(acc, 0, true), nil
+	}
+	acc := nums[0].f
+	for _, n := range nums[1:] {
+		if acc, err = floats(acc, n.f); err != nil {
+			return nil, err
+		}
+	}
+	return numberResult(0, acc, false), nil
+}
+
+// Add calculates the sum of the given numbers. The result is an integer if all of the inputs are integers.
+func Add(values ...interface{}) (interface{}, error) {
+	return reduceNumbers("add", values,
+		func(a, b int64) (int64, error) {
+			if c := a + b; (c > a) == (b > 0) {
+				return c, nil
+			}
+			return 0, integerOverflow(a, "+", b)
+		},
+		func(a, b float64) (float64, error) { return a + b, nil })
+}
+
+// Sub subtracts each subsequent number from the first.
+func Sub(values ...interface{}) (interface{}, error) {
+	return reduceNumbers("sub", values,
+		func(a, b int64) (int64, error) {
+			if c := a - b; (c < a) == (b > 0) {
+				return c, nil
+			}
+			return 0, integerOverflow(a, "-", b)
+		},
+		func(a, b float64) (float64, error) { return a - b, nil })
+}
+
+// Mul calculates the product of the given numbers.
+func Mul(values ...interface{}) (interface{}, error) {
+	return reduceNumbers("mul", values,
+		func(a, b int64) (int64, error) {
+			if a == 0 || b == 0 {
+				return 0, nil
+			}
+			c := a * b
+			if c/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+				return 0, integerOverflow(a, "*", b)
+			}
+			return c, nil
+		},
+		func(a, b float64) (float64, error) { return a * b, nil })
+}
+
+// Div divides the first number by each subsequent number. Integer inputs use integer division.
+func Div(values ...interface{}) (interface{}, error) {
+	return reduceNumbers("div", values,
+		func(a, b int64) (int64, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			if a == math.MinInt64 && b == -1 {
+				return 0, integerOverflow(a, "/", b)
+			}
+			return a / b, nil
+		},
+		func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return a / b, nil
+		})
+}
+
+// Mod returns the remainder of dividing a by b.
+func Mod(a, b interface{}) (interface{}, error) {
+	return reduceNumbers("mod", []interface{}{a, b},
+		func(a, b int64) (int64, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return a % b, nil
+		},
+		func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			return math.Mod(a, b), nil
+		})
+}
+
+// Min returns the smallest of the given numbers.
+func Min(values ...interface{}) (interface{}, error) {
+	return reduceNumbers("min", values,
+		func(a, b int64) (int64, error) {
+			if b < a {
+				return b, nil
+			}
+			return a, nil
+		},
+		func(a, b float64) (float64, error) { return math.Min(a, b), nil })
+}
+
+// Max returns the largest of the given numbers.
+func Max(values ...interface{}) (interface{}, error) {
+	return reduceNumbers("max", values,
+		func(a, b int64) (int64, error) {
+			if b > a {
+				return b, nil
+			}
+			return a, nil
+		},
+		func(a, b float64) (float64, error) { return math.Max(a, b), nil })
+}
+
+// Round rounds a number half away from zero to the given number of decimal places (default 0).
+func Round(value interface{}, places ...interface{}) (interface{}, error) {
+	n, err := toNumber(value)
+	if err != nil {
+		return nil, err
+	}
+	p := int64(0)
+	if len(places) > 1 {
+		return nil, fmt.Errorf("round accepts at most 2 arguments")
+	} else if len(places) == 1 {
+		pn, err := toNumber(places[0])
+		if err != nil || !pn.isInt {
+			return nil, fmt.Errorf("decimal places must be an integer")
+		}
+		p = pn.i
+	}
+	if n.isInt && p >= 0 {
+		return numberResult(n.i, 0, true), nil
+	}
+	scale := math.Pow(10, float64(p))
+	rounded := math.Round(n.f*scale) / scale
+	if p <= 0 {
+		i, err := floatToInt("round", rounded)
+		return numberResult(i, 0, true), err
+	}
+	return rounded, nil
+}
+
+// Floor returns the greatest integer less than or equal to the number.
+func Floor(value interface{}) (interface{}, error) {
+	n, err := toNumber(value)
+	if err != nil {
+		return nil, err
+	}
+	if n.isInt {
+		return numberResult(n.i, 0, true), nil
+	}
+	i, err := floatToInt("floor", math.Floor(n.f))
+	return numberResult(i, 0, true), err
+}
+
+// Ceil returns the least integer greater than or equal to the number.
+func Ceil(value interface{}) (interface{}, error) {
+	n, err := toNumber(value)
+	if err != nil {
+		return nil, err
+	}
+	if n.isInt {
+		return numberResult(n.i, 0, true), nil
+	}
+	i, err := floatToInt("ceil", math.Ceil(n.f))
+	return numberResult(i, 0, true), err
+}
+
+// ToInt converts a number or numeric string to an integer, truncating any decimals.
+func ToInt(value interface{}) (int, error) {
+	n, err := toNumber(value)
+	if err != nil {
+		return 0, err
+	}
+	if n.isInt {
+		return int(n.i), nil
+	}
+	i, err := floatToInt("int", math.Trunc(n.f))
+	return int(i), err
+}
+
+// ToFloat converts a number or numeric string to a float.
+func ToFloat(value interface{}) (float64, error) {
+	n, err := toNumber(value)
+	if err != nil {
+		return 0, err
+	}
+	return n.f, nil
+}
+
+// Seq generates a sequence of integers in the same way as the unix `seq` command:
+//
+//	seq 3       -> 1 2 3
+//	seq 2 4     -> 2 3 4
+//	seq 0 5 15  -> 0 5 10 15
+//	seq 3 1     -> 3 2 1
+func Seq(args ...interface{}) ([]int, error) {
+	var bounds []int64
+	for _, a := range args {
+		n, err := toNumber(a)
+		if err != nil {
+			return nil, err
+		}
+		if !n.isInt {
+			return nil, fmt.Errorf("%v is not an integer", a)
+		}
+		bounds = append(bounds, n.i)
+	}
+	var first, step, last int64
+	switch len(bounds) {
+	case 1:
+		first, step, last = 1, 1, bounds[0]
+		if last < 1 {
+			return []int{}, nil
+		}
+	case 2:
+		first, step, last = bounds[0], 1, bounds[1]
+		if last < first {
+			step = -1
+		}
+	case 3:
+		first, step, last = bounds[0], bounds[1], bounds[2]
+		if step == 0 {
+			return nil, fmt.Errorf("step cannot be 0")
+		}
+	default:
+		return nil, fmt.Errorf("seq accepts 1 to 3 arguments")
+	}
+	return buildSequence(first, step, last)
+}
+
+// Until generates the integers from 0 up to but excluding n.
+func Until(n interface{}) ([]int, error) {
+	count, err := toNumber(n)
+	if err != nil {
+		return nil, err
+	}
+	if !count.isInt {
+		return nil, fmt.Errorf("%v is not an integer", n)
+	}
+	if count.i <= 0 {
+		return []int{}, nil
+	}
+	return buildSequence(0, 1, count.i-1)
+}
+
+func buildSequence(first, step, last int64) ([]int, error) {
+	out := []int{}
+	for i := first; (step > 0 && i <= last) || (step < 0 && i >= last); i += step {
+		if len(out) >= maxSequenceLength {
+			return nil, fmt.Errorf("sequence is longer than the maximum of %d items", maxSequenceLength)
+		}
+		out = append(out, int(i))
+		// stop before the next step wraps around past the end of the 64 bit range
+		if (step > 0 && i > math.MaxInt64-step) || (step < 0 && i < math.MinInt64-step) {
+			break
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIntegerConversions(t *testing.T) {
+	cases := []struct {
+		name     string
+		function func(interface{}) (interface{}, error)
+		input    interface{}
+		want     interface{}
+	}{
+		{"floor", Floor, 2.7, 2},
+		{"floor negative", Floor, -2.2, -3},
+		{"floor int", Floor, math.MaxInt64, math.MaxInt64},
+		{"floor lowest", Floor, float64(math.MinInt64), math.MinInt64},
+		{"ceil", Ceil, 2.2, 3},
+		{"ceil negative", Ceil, -2.7, -2},
+		{"round", func(v interface{}) (interface{}, error) { return Round(v) }, 2.5, 3},
+		{"round negative places", func(v interface{}) (interface{}, error) { return Round(v, -2) }, 1250.0, 1300},
+		{"int", func(v interface{}) (interface{}, error) { return ToInt(v) }, -2.9, -2},
+	}
+	for _, c := range cases {
+		got, err := c.function(c.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.name, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %#v, want %#v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestIntegerConversionOverflow(t *testing.T) {
+	cases := []struct {
+		name     string
+		function func(interface{}) (interface{}, error)
+		input    interface{}
+		want     string
+	}{
+		{"floor", Floor, 1e30, "floor of 1e+30 does not fit in a 64 bit integer"},
+		{"floor 2^63", Floor, math.Pow(2, 63), "does not fit in a 64 bit integer"},
+		{"floor negative", Floor, -1e30, "floor of -1e+30 does not fit in a 64 bit integer"},
+		{"ceil", Ceil, 1e19, "ceil of 1e+19 does not fit in a 64 bit integer"},
+		{"ceil infinity", Ceil, math.Inf(1), "ceil of +Inf does not fit in a 64 bit integer"},
+		{"round", func(v interface{}) (interface{}, error) { return Round(v) }, -1e20, "round of -1e+20 does not fit in a 64 bit integer"},
+		{"int", func(v interface{}) (interface{}, error) { return ToInt(v) }, "1e30", "int of 1e+30 does not fit in a 64 bit integer"},
+		{"int nan", func(v interface{}) (interface{}, error) { return ToInt(v) }, math.NaN(), "int of NaN does not fit in a 64 bit integer"},
+	}
+	for _, c := range cases {
+		_, err := c.function(c.input)
+		if err == nil {
+			t.Errorf("%s: expected an error containing %q", c.name, c.want)
+		} else if !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: got error %q, want it to contain %q", c.name, err, c.want)
+		}
+	}
+}
+
+func TestArithmeticOverflow(t *testing.T) {
+	cases := []struct {
+		name     string
+		function func(...interface{}) (interface{}, error)
+		args     []interface{}
+	}{
+		{"add", Add, []interface{}{math.MaxInt64, 1}},
+		{"sub", Sub, []interface{}{math.MinInt64, 1}},
+		{"mul", Mul, []interface{}{math.MaxInt64, 2}},
+		{"div", Div, []interface{}{math.MinInt64, -1}},
+	}
+	for _, c := range cases {
+		_, err := c.function(c.args...)
+		if err == nil || !strings.Contains(err.Error(), "integer overflow") {
+			t.Errorf("%s: expected an integer overflow error but got %v", c.name, err)
+		}
+	}
+}
+
+func TestSeq(t *testing.T) {
+	cases := []struct {
+		args []interface{}
+		want []int
+	}{
+		{[]interface{}{3}, []int{1, 2, 3}},
+		{[]interface{}{0}, []int{}},
+		{[]interface{}{2, 4}, []int{2, 3, 4}},
+		{[]interface{}{3, 1}, []int{3, 2, 1}},
+		{[]interface{}{0, 5, 12}, []int{0, 5, 10}},
+		{[]interface{}{int64(math.MaxInt64 - 1), int64(math.MaxInt64)}, []int{math.MaxInt64 - 1, math.MaxInt64}},
+		{[]interface{}{int64(math.MinInt64 + 1), int64(math.MinInt64)}, []int{math.MinInt64 + 1, math.MinInt64}},
+		{[]interface{}{int64(math.MaxInt64 - 5), 4, int64(math.MaxInt64)}, []int{math.MaxInt64 - 5, math.MaxInt64 - 1}},
+	}
+	for _, c := range cases {
+		got, err := Seq(c.args...)
+		if err != nil {
+			t.Errorf("seq %v: unexpected error: %s", c.args, err)
+		} else if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("seq %v: got %v, want %v", c.args, got, c.want)
+		}
+	}
+	if _, err := Seq(0, 1, maxSequenceLength); err == nil {
+		t.Error("expected an error for a sequence longer than the maximum")
+	}
+}
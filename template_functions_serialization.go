@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"reflect"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// normalize recursively converts the structures produced by yaml.v2 into ones that every encoder can handle. Maps of
+// any key type become map[string]interface{} with the keys in their printed form and slices become []interface{}.
+func normalize(in interface{}) interface{} {
+	v := indirect(reflect.ValueOf(in))
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		out := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			out[fmt.Sprint(k.Interface())] = normalize(v.MapIndex(k).Interface())
+		}
+		return out
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+		out := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out[i] = normalize(v.Index(i).Interface())
+		}
+		return out
+	}
+	return v.Interface()
+}
+
+// normalizeJSONNumbers converts the json.Number values produced by a decoder using UseNumber into int or float64 so
+// that they match the types produced by the YAML decoder.
+func normalizeJSONNumbers(in interface{}) interface{} {
+	switch v := in.(type) {
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return int(i)
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = normalizeJSONNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeJSONNumbers(item)
+		}
+	}
+	return in
+}
+
+// ToJSON encodes any value as compact JSON. Map keys are sorted. Like the other encoders, the result is not escaped by
+// the template engine so that it can be written straight into a config file.
+func ToJSON(in interface{}) (template.HTML, error) {
+	raw, err := json.Marshal(normalize(in))
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(raw), nil
+}
+
+// ToPrettyJSON encodes any value as indented JSON. The indent defaults to 4 spaces and may be given as a number of
+// spaces or as a string: `toPrettyJson 2 .data` or `.data | toPrettyJson "\t"`.
+func ToPrettyJSON(args ...interface{}) (template.HTML, error) {
+	indent := "    "
+	switch len(args) {
+	case 1:
+	case 2:
+		switch i := args[0].(type) {
+		case string:
+			indent = i
+		default:
+			n, err := toNumber(i)
+			if err != nil || !n.isInt || n.i < 0 {
+				return "", fmt.Errorf("the indent must be a string or a positive number of spaces but got %v", args[0])
+			}
+			indent = strings.Repeat(" ", int(n.i))
+		}
+	default:
+		return "", fmt.Errorf("toPrettyJson accepts an optional indent and a value")
+	}
+	raw, err := json.MarshalIndent(normalize(args[len(args)-1]), "", indent)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(raw), nil
+}
+
+// ToYAML encodes any value as YAML. Map keys are sorted.
+func ToYAML(in interface{}) (template.HTML, error) {
+	raw, err := yaml.Marshal(normalize(in))
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(strings.TrimSuffix(string(raw), "\n")), nil
+}
+
+// ToTOML encodes a map as a TOML document.
+func ToTOML(in interface{}) (template.HTML, error) {
+	out, err := encodeTOML(in)
+	return template.HTML(out), err
+}
+
+// FromJSON decodes a JSON document. Integers are decoded as int rather than float64 so that they behave like the values
+// in the spec.
+func FromJSON(in string) (interface{}, error) {
+	var out interface{}
+	dec := json.NewDecoder(bytes.NewReader([]byte(in)))
+	dec.UseNumber()
+	if err := dec.Decode(&out); err != nil {
+		return nil, fmt.Errorf("could not parse json: %s", err)
+	}
+	return normalizeJSONNumbers(out), nil
+}
+
+// FromYAML decodes a YAML document into the same shapes as the spec file.
+func FromYAML(in string) (interface{}, error) {
+	var out interface{}
+	if err := yaml.Unmarshal([]byte(in), &out); err != nil {
+		return nil, fmt.Errorf("could not parse yaml: %s", err)
+	}
+	return out, nil
+}
+
+// Jsonify is the original name of ToJSON, kept for the `json` template function. Its output is escaped as it always
+// has been.
+func Jsonify(in interface{}) (string, error) {
+	out, err := ToJSON(in)
+	return string(out), err
+}
+
+// JsonifyIndent is the original name of ToPrettyJSON, kept for the `jsonindent` template function. Its output is
+// escaped as it always has been.
+func JsonifyIndent(in interface{}) (string, error) {
+	out, err := ToPrettyJSON(in)
+	return string(out), err
+}
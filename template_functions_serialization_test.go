@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+func TestSerializationFunctionsAreNotEscaped(t *testing.T) {
+	spec := map[string]interface{}{
+		"m": map[interface{}]interface{}{"a": 1, "b": `<"x">`},
+	}
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{`{{ toJson .m }}`, `{"a":1,"b":"\u003c\"x\"\u003e"}`},
+		{`{{ toPrettyJson 1 .m }}`, "{\n \"a\": 1,\n \"b\": \"\\u003c\\\"x\\\"\\u003e\"\n}"},
+		{`{{ toYaml .m }}`, "a: 1\nb: <\"x\">"},
+		{`{{ toToml .m }}`, "a = 1\nb = \"<\\\"x\\\">\"\n"},
+		{`{{ json .m }}`, `{&#34;a&#34;:1,&#34;b&#34;:&#34;\u003c\&#34;x\&#34;\u003e&#34;}`},
+		{`{{ json .m | unescape }}`, `{"a":1,"b":"\u003c\"x\"\u003e"}`},
+		{`{{ toJson .m | unescape }}`, `{"a":1,"b":"\u003c\"x\"\u003e"}`},
+	}
+	for _, c := range cases {
+		tf := templatefactory.NewTemplateFactory()
+		if err := tf.SetSpec(&spec); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		registerTemplateFunctions(tf)
+		got, err := tf.Render(templatefactory.Source{Path: "test"}, c.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.input, err)
+		} else if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.input, got, c.want)
+		}
+	}
+}
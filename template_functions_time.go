@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+// sourceDateEpochEnv is the reproducible-builds.org variable for pinning the clock to a unix timestamp.
+const sourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// clock is the source of the current time for all of the time template functions. When it is pinned, every run
+// produces the same output.
+type clock struct {
+	fixed *time.Time
+}
+
+// newClock builds a clock from the -now flag or the SOURCE_DATE_EPOCH environment variable, the flag taking precedence.
+// If neither is set the clock follows the real time.
+func newClock(nowFlag string) (*clock, error) {
+	if nowFlag != "" {
+		t, err := time.Parse(time.RFC3339, nowFlag)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse -now '%s' as an RFC3339 time: %s", nowFlag, err)
+		}
+		return &clock{fixed: &t}, nil
+	}
+	if epoch := os.Getenv(sourceDateEpochEnv); epoch != "" {
+		seconds, err := strconv.ParseInt(strings.TrimSpace(epoch), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse $%s '%s' as a unix timestamp: %s", sourceDateEpochEnv, epoch, err)
+		}
+		t := time.Unix(seconds, 0).UTC()
+		return &clock{fixed: &t}, nil
+	}
+	return &clock{}, nil
+}
+
+// Now returns the pinned time or the real current time.
+func (c *clock) Now() time.Time {
+	if c.fixed != nil {
+		return *c.fixed
+	}
+	return time.Now()
+}
+
+// timeArg converts the optional time argument of the date functions. A missing argument is the current time, and
+// strings and unix timestamps are accepted as well as time values.
+func (c *clock) timeArg(args []interface{}) (time.Time, error) {
+	if len(args) == 0 {
+		return c.Now(), nil
+	}
+	if len(args) > 1 {
+		return time.Time{}, fmt.Errorf("expected at most one time argument but got %d", len(args))
+	}
+	switch v := args[0].(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		return *v, nil
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("could not parse '%s' as an RFC3339 time", v)
+		}
+		return t, nil
+	}
+	n, err := toNumber(args[0])
+	if err != nil || !n.isInt {
+		return time.Time{}, fmt.Errorf("expected a time, RFC3339 string or unix timestamp but got %T", args[0])
+	}
+	return time.Unix(n.i, 0).UTC(), nil
+}
+
+// Date formats a time using a Go reference layout: `date "2006-01-02"` or `date "15:04" .when`.
+func (c *clock) Date(layout string, t ...interface{}) (string, error) {
+	when, err := c.timeArg(t)
+	if err != nil {
+		return "", err
+	}
+	return when.Format(layout), nil
+}
+
+var durationDaysRegexp = regexp.MustCompile(`(\d+)([dw])`)
+
+// parseDuration extends time.ParseDuration with days ("d") and weeks ("w"). As with time.ParseDuration a leading sign
+// applies to the whole duration, so "-1d2h" is 26 hours in the past.
+func parseDuration(in string) (time.Duration, error) {
+	sign := time.Duration(1)
+	unsigned := in
+	if strings.HasPrefix(unsigned, "-") {
+		sign, unsigned = -1, unsigned[1:]
+	} else if strings.HasPrefix(unsigned, "+") {
+		unsigned = unsigned[1:]
+	}
+	var days time.Duration
+	rest := durationDaysRegexp.ReplaceAllStringFunc(unsigned, func(m string) string {
+		parts := durationDaysRegexp.FindStringSubmatch(m)
+		n, _ := strconv.Atoi(parts[1])
+		unit := 24 * time.Hour
+		if parts[2] == "w" {
+			unit *= 7
+		}
+		days += time.Duration(n) * unit
+		return ""
+	})
+	if unsigned == "" || strings.HasPrefix(rest, "-") || strings.HasPrefix(rest, "+") {
+		return 0, fmt.Errorf("could not parse duration '%s'", in)
+	}
+	if rest == "" {
+		return sign * days, nil
+	}
+	d, err := time.ParseDuration(rest)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse duration '%s'", in)
+	}
+	return sign * (days + d), nil
+}
+
+// DateAdd adds a duration such as "36h", "-2d" or "1w2d" to a time: `now | dateAdd "24h"`.
+func (c *clock) DateAdd(duration string, t ...interface{}) (time.Time, error) {
+	when, err := c.timeArg(t)
+	if err != nil {
+		return time.Time{}, err
+	}
+	d, err := parseDuration(duration)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return when.Add(d), nil
+}
+
+// DateParse parses a time using a Go reference layout: `dateParse "2006-01-02" .released`.
+func (c *clock) DateParse(layout, value string) (time.Time, error) {
+	return time.Parse(layout, value)
+}
+
+// UnixEpoch returns the unix timestamp in seconds of a time, or of the current time.
+func (c *clock) UnixEpoch(t ...interface{}) (int64, error) {
+	when, err := c.timeArg(t)
+	if err != nil {
+		return 0, err
+	}
+	return when.Unix(), nil
+}
+
+// InTimezone converts a time to the named IANA timezone: `now | inTimezone "Europe/London"`.
+func (c *clock) InTimezone(zone string, t ...interface{}) (time.Time, error) {
+	when, err := c.timeArg(t)
+	if err != nil {
+		return time.Time{}, err
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown timezone '%s'", zone)
+	}
+	return when.In(loc), nil
+}
+
+// registerTimeFunctions adds the time functions, all of which use the given clock.
+func registerTimeFunctions(tf *templatefactory.TemplateFactory, c *clock) {
+	tf.RegisterTemplateFunction("now", c.Now)
+	tf.RegisterTemplateFunction("date", c.Date)
+	tf.RegisterTemplateFunction("dateAdd", c.DateAdd)
+	tf.RegisterTemplateFunction("dateParse", c.DateParse)
+	tf.RegisterTemplateFunction("unixEpoch", c.UnixEpoch)
+	tf.RegisterTemplateFunction("inTimezone", c.InTimezone)
+}
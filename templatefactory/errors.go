@@ -0,0 +1,168 @@
+package templatefactory
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Source identifies where a template came from so that errors can point back at it.
+type Source struct {
+	// Path is the file, or other origin, of the template.
+	Path string
+	// Kind says which part of the file the template is, such as "name" or "content".
+	Kind string
+	// Line and Column are the 1-based position in Path that the template text starts at. Zero is treated as 1, so
+	// templates that make up a whole file can leave them unset.
+	Line   int
+	Column int
+}
+
+// String returns the name given to the template, which includes the kind so that templates from the same file can be
+// told apart.
+func (s Source) String() string {
+	if s.Kind == "" {
+		return s.Path
+	}
+	return s.Path + " (" + s.Kind + ")"
+}
+
+func (s Source) firstLine() int {
+	if s.Line < 1 {
+		return 1
+	}
+	return s.Line
+}
+
+func (s Source) firstColumn() int {
+	if s.Column < 1 {
+		return 1
+	}
+	return s.Column
+}
+
+// TemplateError is returned by Render and Evaluate when a template fails to parse or execute. It records the position of
+// the failure in the source file and keeps the template text so that the offending line can be shown.
+type TemplateError struct {
+	Source Source
+	// Line and Column are the 1-based position in the source file, zero when the template engine did not report them.
+	Line   int
+	Column int
+	// Description is the error reported by the template engine without its position prefix.
+	Description string
+
+	text       string
+	textLine   int
+	textColumn int
+	err        error
+}
+
+func (e *TemplateError) Error() string {
+	switch {
+	case e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", e.Source.Path, e.Line, e.Column, e.Description)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.Source.Path, e.Line, e.Description)
+	}
+	return fmt.Sprintf("%s: %s", e.Source.Path, e.Description)
+}
+
+// Unwrap returns the error reported by the template engine, which in turn wraps any error returned by a template
+// function.
+func (e *TemplateError) Unwrap() error {
+	return e.err
+}
+
+// Snippet returns the offending line of the template with a caret under the column, or an empty string when the
+// position is not known.
+func (e *TemplateError) Snippet() string {
+	lines := strings.Split(e.text, "\n")
+	if e.textLine < 1 || e.textLine > len(lines) {
+		return ""
+	}
+	line := strings.TrimRight(lines[e.textLine-1], "\r")
+	number := strconv.Itoa(e.Line)
+	gutter := strings.Repeat(" ", len(number))
+
+	var b strings.Builder
+	location := e.Source.Path + ":" + number
+	if e.Column > 0 {
+		location += ":" + strconv.Itoa(e.Column)
+	}
+	if e.Source.Kind != "" {
+		location += " (" + e.Source.Kind + ")"
+	}
+	fmt.Fprintf(&b, "%s--> %s\n", gutter, location)
+	fmt.Fprintf(&b, "%s |\n", gutter)
+	fmt.Fprintf(&b, "%s | %s\n", number, line)
+	if e.textColumn > 0 {
+		// keep tabs so that the caret lines up with the text above it
+		var pad strings.Builder
+		for i, r := range line {
+			if i >= e.textColumn-1 {
+				break
+			}
+			if r == '\t' {
+				pad.WriteRune('\t')
+			} else {
+				pad.WriteRune(' ')
+			}
+		}
+		fmt.Fprintf(&b, "%s | %s^\n", gutter, pad.String())
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// newTemplateError converts an error from the template engine into a TemplateError. The engine reports positions
+// relative to the text it was given, prefixed by the template name, so these are parsed back out and moved to the
+// position of the text in its source. The column offset is the number of bytes that were added in front of the first
+// line before it was given to the engine.
+func newTemplateError(src Source, text string, columnOffset int, err error) error {
+	name := src.String()
+	e := &TemplateError{Source: src, Description: err.Error(), text: text, err: err}
+	for _, prefix := range []string{"template: " + name + ":", "html/template:" + name + ":"} {
+		if strings.HasPrefix(e.Description, prefix) {
+			e.Description = strings.TrimSpace(e.Description[len(prefix):])
+			var ok bool
+			if e.textLine, e.Description, ok = leadingNumber(e.Description); ok {
+				// the engine counts columns from 0
+				if e.textColumn, e.Description, ok = leadingNumber(e.Description); ok {
+					e.textColumn++
+				}
+			}
+			break
+		}
+	}
+	e.Description = strings.TrimPrefix(e.Description, "executing \""+name+"\" ")
+	if e.textLine > 0 {
+		e.Line = e.textLine + src.firstLine() - 1
+	}
+	if e.textColumn > 0 {
+		e.Column = e.textColumn
+		if e.textLine == 1 {
+			e.textColumn -= columnOffset
+			if e.textColumn < 1 {
+				e.textColumn = 1
+			}
+			e.Column = e.textColumn + src.firstColumn() - 1
+		}
+	}
+	return e
+}
+
+// leadingNumber splits a "12: rest" or "12:rest" prefix from the text. It returns false and the unchanged text when
+// there is no number.
+func leadingNumber(text string) (int, string, bool) {
+	i := 0
+	for i < len(text) && text[i] >= '0' && text[i] <= '9' {
+		i++
+	}
+	if i == 0 || i == len(text) || text[i] != ':' {
+		return 0, text, false
+	}
+	n, err := strconv.Atoi(text[:i])
+	if err != nil {
+		return 0, text, false
+	}
+	return n, strings.TrimSpace(text[i+1:]), true
+}
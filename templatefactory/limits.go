@@ -0,0 +1,72 @@
+package templatefactory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html/template"
+	"time"
+)
+
+// ErrRenderTimeout is returned, wrapped in a TemplateError, when a render runs for longer than the render timeout.
+var ErrRenderTimeout = errors.New("rendering took longer than the render timeout")
+
+// ErrOutputLimit is returned, wrapped in a TemplateError, when a render produces more output than the output limit.
+var ErrOutputLimit = errors.New("rendering produced more output than the output limit")
+
+// SetLimits bounds how long a single Render or Evaluate may run and how many bytes it may produce. Zero disables a
+// limit.
+func (f *TemplateFactory) SetLimits(timeout time.Duration, maxOutput int64) {
+	f.timeout = timeout
+	f.maxOutput = maxOutput
+}
+
+// limitedWriter fails writes once the deadline has passed or the output limit is reached, which stops templates that
+// keep producing output as soon as they break a limit.
+type limitedWriter struct {
+	ctx   context.Context
+	buf   bytes.Buffer
+	limit int64
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, ErrRenderTimeout
+	}
+	if w.limit > 0 && int64(w.buf.Len()+len(p)) > w.limit {
+		return 0, ErrOutputLimit
+	}
+	return w.buf.Write(p)
+}
+
+// execute runs the template against the spec within the limits. Templates that exceed the timeout without writing
+// anything, such as a long range with no output, are abandoned in the background.
+func (f *TemplateFactory) execute(t *template.Template) (string, error) {
+	if f.timeout == 0 && f.maxOutput == 0 {
+		var buf bytes.Buffer
+		err := t.Execute(&buf, f.spec)
+		return buf.String(), err
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if f.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), f.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	w := &limitedWriter{ctx: ctx, limit: f.maxOutput}
+	done := make(chan error, 1)
+	go func() {
+		done <- t.Execute(w, f.spec)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return "", err
+		}
+		return w.buf.String(), nil
+	case <-ctx.Done():
+		return "", ErrRenderTimeout
+	}
+}
@@ -1,11 +1,11 @@
 package templatefactory
 
 import (
-	"bytes"
 	"fmt"
 	"html/template"
 	"reflect"
 	"strings"
+	"time"
 )
 
 const SpecialDelimitersKey = "_spiro_delimiters_"
@@ -15,6 +15,8 @@ type TemplateFactory struct {
 	startDelim string
 	endDelim   string
 	spec       *map[string]interface{}
+	timeout    time.Duration
+	maxOutput  int64
 }
 
 func NewTemplateFactory() *TemplateFactory {
@@ -46,17 +48,55 @@ func (f *TemplateFactory) StringContainsTemplating(in string) bool {
 	return strings.Contains(in, f.startDelim) && strings.Contains(in, f.endDelim)
 }
 
+func (f *TemplateFactory) Delimiters() (string, string) {
+	return f.startDelim, f.endDelim
+}
+
 func (f *TemplateFactory) RegisterTemplateFunction(name string, function interface{}) {
 	f.funcMap[name] = function
 }
 
-func (f *TemplateFactory) Render(templateString string) (string, error) {
-	t := template.New("").Option("missingkey=error").Funcs(f.funcMap).Delims(f.startDelim, f.endDelim)
-	if t, err := t.Parse(templateString); err != nil {
-		return "", err
-	} else {
-		var buf bytes.Buffer
-		err := t.Execute(&buf, f.spec)
-		return buf.String(), err
+func (f *TemplateFactory) HasTemplateFunction(name string) bool {
+	_, ok := f.funcMap[name]
+	return ok
+}
+
+// evaluateResultFunction is the name of the function used by Evaluate to capture the value of an expression.
+const evaluateResultFunction = "_spiro_result_"
+
+// Evaluate evaluates a single template expression, written without delimiters (for example `lower .name`), against the
+// spec and returns its value without converting it to a string. Errors are returned as a *TemplateError pointing at the
+// source.
+func (f *TemplateFactory) Evaluate(src Source, expression string) (interface{}, error) {
+	var result interface{}
+	funcMap := make(template.FuncMap, len(f.funcMap)+1)
+	for name, function := range f.funcMap {
+		funcMap[name] = function
+	}
+	funcMap[evaluateResultFunction] = func(value interface{}) string {
+		result = value
+		return ""
+	}
+	prefix := f.startDelim + " " + evaluateResultFunction + " ("
+	t := template.New(src.String()).Option("missingkey=error").Funcs(funcMap).Delims(f.startDelim, f.endDelim)
+	if _, err := t.Parse(prefix + expression + ") " + f.endDelim); err != nil {
+		return nil, newTemplateError(src, expression, len(prefix), err)
+	}
+	if _, err := f.execute(t); err != nil {
+		return nil, newTemplateError(src, expression, len(prefix), err)
+	}
+	return result, nil
+}
+
+// Render renders the template string against the spec. Errors are returned as a *TemplateError pointing at the source.
+func (f *TemplateFactory) Render(src Source, templateString string) (string, error) {
+	t := template.New(src.String()).Option("missingkey=error").Funcs(f.funcMap).Delims(f.startDelim, f.endDelim)
+	if _, err := t.Parse(templateString); err != nil {
+		return "", newTemplateError(src, templateString, 0, err)
+	}
+	output, err := f.execute(t)
+	if err != nil {
+		return "", newTemplateError(src, templateString, 0, err)
 	}
+	return output, nil
 }
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+// testsDirName is the directory in the root of a template that holds its test cases. It is never copied to the output.
+// Each case is a directory containing a spec file named `spec` with any supported extension, and an `expected`
+// directory with the output that spiro should produce for it.
+const testsDirName = ".spiro-tests"
+
+// testExpectedDirName is the directory of a test case that holds the expected output.
+const testExpectedDirName = "expected"
+
+// defaultTestClock is the time that the time functions return while testing, so that goldens are stable.
+const defaultTestClock = "2000-01-01T00:00:00Z"
+
+// findTestSpec returns the spec file of a test case.
+func findTestSpec(caseDir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(caseDir, "spec.*"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("the test case has no spec file")
+	}
+	if len(matches) > 1 {
+		return "", fmt.Errorf("the test case has more than one spec file")
+	}
+	return matches[0], nil
+}
+
+// readExpectedOutput reads the files of an expected output directory, keyed by slash separated relative path.
+func readExpectedOutput(dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	err := filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && filePath == dir {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = content
+		return nil
+	})
+	return files, err
+}
+
+// compareTestOutput returns a description of every difference between the expected and the rendered files.
+func compareTestOutput(expected map[string][]byte, rendered *memoryOutput) []string {
+	var problems []string
+	for _, filePath := range rendered.filePaths() {
+		want, ok := expected[filePath]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unexpected file '%s'", filePath))
+			continue
+		}
+		if diff := unifiedDiff("expected/"+filePath, "rendered/"+filePath, string(want), string(rendered.files[filePath].content)); diff != "" {
+			problems = append(problems, diff)
+		}
+	}
+	var missing []string
+	for filePath := range expected {
+		if _, ok := rendered.files[filePath]; !ok {
+			missing = append(missing, filePath)
+		}
+	}
+	sort.Strings(missing)
+	for _, filePath := range missing {
+		problems = append(problems, fmt.Sprintf("expected file '%s' was not rendered", filePath))
+	}
+	return problems
+}
+
+// writeExpectedOutput replaces the expected output directory with the rendered files.
+func writeExpectedOutput(dir string, rendered *memoryOutput) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, filePath := range rendered.filePaths() {
+		target := filepath.Join(dir, filepath.FromSlash(filePath))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		file := rendered.files[filePath]
+		if err := ioutil.WriteFile(target, file.content, file.mode.Perm()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runTemplateTest renders a single test case into memory and compares, or with update replaces, the expected output.
+func runTemplateTest(caseDir string, settings *renderSettings, update bool) ([]string, error) {
+	specFile, err := findTestSpec(caseDir)
+	if err != nil {
+		return nil, err
+	}
+	specFormat, err := detectSpecFormat(specFile, "")
+	if err != nil {
+		return nil, err
+	}
+	specContents, err := readSpecRaw(specFile)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := decodeSpec(specContents, specFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := newMemoryOutput()
+	caseSettings := *settings
+	caseSettings.output = rendered
+	// the expected output mirrors the template's contents whatever its directory is called
+	caseSettings.rootContents = true
+	if _, err := renderSpec(spec, &caseSettings, func(*templatefactory.TemplateFactory) (string, error) {
+		return ".", nil
+	}, nil); err != nil {
+		return nil, err
+	}
+
+	expectedDir := filepath.Join(caseDir, testExpectedDirName)
+	if update {
+		return nil, writeExpectedOutput(expectedDir, rendered)
+	}
+	expected, err := readExpectedOutput(expectedDir)
+	if err != nil {
+		return nil, err
+	}
+	return compareTestOutput(expected, rendered), nil
+}
+
+// runTemplateTests implements `spiro test`: it runs every test case of the template and reports the results.
+func runTemplateTests(settings *renderSettings, update bool, out io.Writer) error {
+	casesDir := filepath.Join(settings.root, testsDirName)
+	items, err := ioutil.ReadDir(casesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Template '%s' has no tests, add test cases to '%s'", settings.inputTemplate, casesDir)
+		}
+		return err
+	}
+
+	// the processing events of each render are noise in the test report
+	level := logger.level
+	if level < logLevelVerbose {
+		logger.level = logLevelQuiet
+	}
+	defer func() {
+		logger.level = level
+	}()
+
+	total, failed := 0, 0
+	for _, item := range items {
+		if !item.IsDir() {
+			continue
+		}
+		total++
+		name := item.Name()
+		problems, err := runTemplateTest(filepath.Join(casesDir, name), settings, update)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Fprintf(out, "FAIL %s\n    %s\n", name, secrets.Redact(err.Error()))
+			if context := templateErrorContext(err); context != "" {
+				fmt.Fprintf(out, "    %s\n", strings.Replace(secrets.Redact(context), "\n", "\n    ", -1))
+			}
+		case len(problems) > 0:
+			failed++
+			fmt.Fprintf(out, "FAIL %s\n", name)
+			for _, problem := range problems {
+				problem = secrets.Redact(strings.TrimRight(problem, "\n"))
+				fmt.Fprintf(out, "    %s\n", strings.Replace(problem, "\n", "\n    ", -1))
+			}
+		case update:
+			fmt.Fprintf(out, "updated %s\n", name)
+		default:
+			fmt.Fprintf(out, "ok   %s\n", name)
+		}
+	}
+	if total == 0 {
+		return fmt.Errorf("Template '%s' has no tests, add test cases to '%s'", settings.inputTemplate, casesDir)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d template tests failed", failed, total)
+	}
+	if update {
+		fmt.Fprintf(out, "%d template tests updated\n", total)
+		return nil
+	}
+	fmt.Fprintf(out, "%d template tests passed\n", total)
+	return nil
+}
+
+// isTestsDir reports whether a template path is the tests directory in the root of the template or one of its bases.
+func (p *processor) isTestsDir(templateString string) bool {
+	return path.Base(templateString) == testsDirName && p.isLayerRoot(path.Dir(templateString))
+}
+
+// testTemplate implements `spiro test`. The clock is pinned so that the output of the time functions is stable.
+func testTemplate(inputTemplate string, config *userConfig, nowFlag string, update bool) error {
+	inputTemplate, cleanup, err := resolveInputTemplate(config, inputTemplate, false)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	if info, err := os.Stat(inputTemplate); err != nil || !info.IsDir() {
+		return fmt.Errorf("Input template '%s' must be a template directory to be tested", inputTemplate)
+	}
+
+	if nowFlag == "" {
+		nowFlag = defaultTestClock
+	}
+	templateClock, err := newClock(nowFlag)
+	if err != nil {
+		return err
+	}
+	settings := &renderSettings{
+		inputTemplate: inputTemplate,
+		root:          inputTemplate,
+		clock:         templateClock,
+		config:        config,
+	}
+	cleanupLayers, err := settings.loadLayers()
+	defer cleanupLayers()
+	if err != nil {
+		return err
+	}
+	stopPlugins, err := settings.startPlugins()
+	defer stopPlugins()
+	if err != nil {
+		return err
+	}
+	return runTemplateTests(settings, update, os.Stdout)
+}
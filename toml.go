@@ -0,0 +1,788 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var tomlBareKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func tomlKey(key string) string {
+	if tomlBareKeyRegexp.MatchString(key) {
+		return key
+	}
+	return tomlString(key)
+}
+
+func tomlString(in string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('"')
+	for _, r := range in {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&buf, `\u%04X`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+func isTOMLTable(v interface{}) bool {
+	_, ok := v.(map[string]interface{})
+	return ok
+}
+
+func isTOMLTableArray(v interface{}) bool {
+	items, ok := v.([]interface{})
+	if !ok || len(items) == 0 {
+		return false
+	}
+	for _, item := range items {
+		if !isTOMLTable(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// tomlValue encodes a value that appears on the right hand side of a key, or inside an array.
+func tomlValue(in interface{}) (string, error) {
+	switch v := in.(type) {
+	case nil:
+		return "", fmt.Errorf("TOML cannot represent null values")
+	case string:
+		return tomlString(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, 0, len(keys))
+		for _, k := range keys {
+			item, err := tomlValue(v[k])
+			if err != nil {
+				return "", fmt.Errorf("%s: %s", k, err)
+			}
+			parts = append(parts, tomlKey(k)+" = "+item)
+		}
+		return "{" + strings.Join(parts, ", ") + "}", nil
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			encoded, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, encoded)
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	}
+	rv := reflect.ValueOf(in)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		switch {
+		case math.IsNaN(f):
+			return "nan", nil
+		case math.IsInf(f, 1):
+			return "inf", nil
+		case math.IsInf(f, -1):
+			return "-inf", nil
+		}
+		out := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(out, ".eE") {
+			out += ".0"
+		}
+		return out, nil
+	}
+	return tomlString(fmt.Sprint(in)), nil
+}
+
+func encodeTOMLTable(buf *bytes.Buffer, path []string, table map[string]interface{}) error {
+	keys := make([]string, 0, len(table))
+	for k := range table {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// plain key/values must come before any sub tables
+	for _, k := range keys {
+		if isTOMLTable(table[k]) || isTOMLTableArray(table[k]) {
+			continue
+		}
+		encoded, err := tomlValue(table[k])
+		if err != nil {
+			return fmt.Errorf("%s: %s", strings.Join(append(path, k), "."), err)
+		}
+		fmt.Fprintf(buf, "%s = %s\n", tomlKey(k), encoded)
+	}
+	for _, k := range keys {
+		subPath := append(append([]string{}, path...), tomlKey(k))
+		if sub, ok := table[k].(map[string]interface{}); ok {
+			if buf.Len() > 0 {
+				buf.WriteByte('\n')
+			}
+			fmt.Fprintf(buf, "[%s]\n", strings.Join(subPath, "."))
+			if err := encodeTOMLTable(buf, subPath, sub); err != nil {
+				return err
+			}
+		} else if isTOMLTableArray(table[k]) {
+			for _, item := range table[k].([]interface{}) {
+				if buf.Len() > 0 {
+					buf.WriteByte('\n')
+				}
+				fmt.Fprintf(buf, "[[%s]]\n", strings.Join(subPath, "."))
+				if err := encodeTOMLTable(buf, subPath, item.(map[string]interface{})); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// encodeTOML encodes a normalized structure as a TOML document. The top level value must be a map.
+func encodeTOML(in interface{}) (string, error) {
+	table, ok := normalize(in).(map[string]interface{})
+	if !ok {
+		return "", fmt.Errorf("TOML documents must be a map at the top level but got %T", in)
+	}
+	var buf bytes.Buffer
+	if err := encodeTOMLTable(&buf, nil, table); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// tomlParser is a small recursive descent parser for TOML documents. It supports the whole of TOML 1.0 apart from
+// some of the more obscure validation rules.
+type tomlParser struct {
+	src  []rune
+	pos  int
+	line int
+
+	root    map[string]interface{}
+	current map[string]interface{}
+	// defined tracks the tables created by a [header] so that redefinitions can be rejected
+	defined map[string]bool
+	// inline tracks the tables written inline as {...} and headed tracks the tables created by a [[header]], by identity.
+	// Inline tables and static arrays can not be extended once they are defined.
+	inline map[uintptr]bool
+	headed map[uintptr]bool
+}
+
+// tableID identifies a table for the inline and headed sets.
+func tableID(table map[string]interface{}) uintptr {
+	return reflect.ValueOf(table).Pointer()
+}
+
+// decodeTOML parses a TOML document into a map.
+func decodeTOML(content []byte) (map[string]interface{}, error) {
+	p := &tomlParser{
+		src:     []rune(string(content)),
+		line:    1,
+		root:    map[string]interface{}{},
+		defined: map[string]bool{},
+		inline:  map[uintptr]bool{},
+		headed:  map[uintptr]bool{},
+	}
+	p.current = p.root
+	if err := p.parse(); err != nil {
+		return nil, fmt.Errorf("line %d: %s", p.line, err)
+	}
+	return p.root, nil
+}
+
+func (p *tomlParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *tomlParser) peek() rune {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *tomlParser) hasPrefix(s string) bool {
+	r := []rune(s)
+	if p.pos+len(r) > len(p.src) {
+		return false
+	}
+	for i := range r {
+		if p.src[p.pos+i] != r[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *tomlParser) next() rune {
+	r := p.src[p.pos]
+	p.pos++
+	if r == '\n' {
+		p.line++
+	}
+	return r
+}
+
+// skipSpace skips spaces and tabs, and comments.
+func (p *tomlParser) skipSpace() {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t':
+			p.next()
+		case '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.next()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// skipWhitespace skips spaces, comments and newlines.
+func (p *tomlParser) skipWhitespace() {
+	for {
+		p.skipSpace()
+		if p.eof() || (p.peek() != '\n' && p.peek() != '\r') {
+			return
+		}
+		p.next()
+	}
+}
+
+func (p *tomlParser) expectLineEnd() error {
+	p.skipSpace()
+	if p.eof() {
+		return nil
+	}
+	if p.peek() == '\r' {
+		p.next()
+	}
+	if p.eof() || p.peek() == '\n' {
+		if !p.eof() {
+			p.next()
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected '%c' at the end of the line", p.peek())
+}
+
+func (p *tomlParser) parse() error {
+	for {
+		p.skipWhitespace()
+		if p.eof() {
+			return nil
+		}
+		if p.hasPrefix("[[") {
+			p.pos += 2
+			if err := p.parseTableArrayHeader(); err != nil {
+				return err
+			}
+		} else if p.peek() == '[' {
+			p.next()
+			if err := p.parseTableHeader(); err != nil {
+				return err
+			}
+		} else {
+			if err := p.parseKeyValue(p.current); err != nil {
+				return err
+			}
+		}
+		if err := p.expectLineEnd(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *tomlParser) parseKeyPart() (string, error) {
+	p.skipSpace()
+	switch p.peek() {
+	case '"':
+		return p.parseBasicString()
+	case '\'':
+		return p.parseLiteralString()
+	}
+	start := p.pos
+	for !p.eof() {
+		r := p.peek()
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' {
+			p.next()
+			continue
+		}
+		break
+	}
+	if start == p.pos {
+		if p.eof() {
+			return "", fmt.Errorf("expected a key but reached the end of the document")
+		}
+		return "", fmt.Errorf("expected a key but found '%c'", p.peek())
+	}
+	return string(p.src[start:p.pos]), nil
+}
+
+func (p *tomlParser) parseKey() ([]string, error) {
+	var parts []string
+	for {
+		part, err := p.parseKeyPart()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part)
+		p.skipSpace()
+		if p.peek() != '.' {
+			return parts, nil
+		}
+		p.next()
+	}
+}
+
+// descend walks to the table at the given path, creating intermediate tables as needed. If the path ends in an array
+// of tables, the last table in the array is used.
+func (p *tomlParser) descend(from map[string]interface{}, path []string) (map[string]interface{}, error) {
+	current := from
+	for _, part := range path {
+		switch existing := current[part].(type) {
+		case nil:
+			table := map[string]interface{}{}
+			current[part] = table
+			current = table
+		case map[string]interface{}:
+			if p.inline[tableID(existing)] {
+				return nil, fmt.Errorf("key '%s' is an inline table and can not be extended", part)
+			}
+			current = existing
+		case []interface{}:
+			if len(existing) == 0 {
+				return nil, fmt.Errorf("key '%s' is not a table", part)
+			}
+			table, ok := existing[len(existing)-1].(map[string]interface{})
+			if !ok || !p.headed[tableID(table)] {
+				return nil, fmt.Errorf("key '%s' is not a table", part)
+			}
+			current = table
+		default:
+			return nil, fmt.Errorf("key '%s' is already defined as a value", part)
+		}
+	}
+	return current, nil
+}
+
+func (p *tomlParser) parseTableHeader() error {
+	path, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if p.eof() || p.next() != ']' {
+		return fmt.Errorf("expected ']' to close the table header")
+	}
+	name := strings.Join(path, "\x00")
+	if p.defined[name] {
+		return fmt.Errorf("table '%s' is defined more than once", strings.Join(path, "."))
+	}
+	p.defined[name] = true
+	p.current, err = p.descend(p.root, path)
+	return err
+}
+
+func (p *tomlParser) parseTableArrayHeader() error {
+	path, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if !p.hasPrefix("]]") {
+		return fmt.Errorf("expected ']]' to close the array of tables header")
+	}
+	p.pos += 2
+	parent, err := p.descend(p.root, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	last := path[len(path)-1]
+	table := map[string]interface{}{}
+	switch existing := parent[last].(type) {
+	case nil:
+		parent[last] = []interface{}{table}
+	case []interface{}:
+		if !p.isTableArray(existing) {
+			return fmt.Errorf("key '%s' is a static array and can not be extended", strings.Join(path, "."))
+		}
+		parent[last] = append(existing, table)
+	default:
+		return fmt.Errorf("key '%s' is already defined and is not an array of tables", strings.Join(path, "."))
+	}
+	p.headed[tableID(table)] = true
+	p.current = table
+	return nil
+}
+
+// isTableArray reports whether an array was created by [[header]]s rather than written as a static array.
+func (p *tomlParser) isTableArray(items []interface{}) bool {
+	if len(items) == 0 {
+		return false
+	}
+	table, ok := items[0].(map[string]interface{})
+	return ok && p.headed[tableID(table)]
+}
+
+func (p *tomlParser) parseKeyValue(into map[string]interface{}) error {
+	path, err := p.parseKey()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if p.eof() || p.next() != '=' {
+		return fmt.Errorf("expected '=' after key '%s'", strings.Join(path, "."))
+	}
+	p.skipSpace()
+	value, err := p.parseValue()
+	if err != nil {
+		return err
+	}
+	table, err := p.descend(into, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	last := path[len(path)-1]
+	if _, exists := table[last]; exists {
+		return fmt.Errorf("key '%s' is defined more than once", strings.Join(path, "."))
+	}
+	table[last] = value
+	return nil
+}
+
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.eof() {
+		return nil, fmt.Errorf("expected a value but reached the end of the document")
+	}
+	switch {
+	case p.hasPrefix(`"""`):
+		return p.parseMultilineBasicString()
+	case p.hasPrefix(`'''`):
+		return p.parseMultilineLiteralString()
+	case p.peek() == '"':
+		return p.parseBasicString()
+	case p.peek() == '\'':
+		return p.parseLiteralString()
+	case p.peek() == '[':
+		return p.parseArray()
+	case p.peek() == '{':
+		table, err := p.parseInlineTable()
+		if err == nil {
+			p.inline[tableID(table)] = true
+		}
+		return table, err
+	case p.hasPrefix("true"):
+		p.pos += 4
+		return true, nil
+	case p.hasPrefix("false"):
+		p.pos += 5
+		return false, nil
+	}
+	return p.parseScalar()
+}
+
+func (p *tomlParser) parseEscape() (string, error) {
+	if p.eof() {
+		return "", fmt.Errorf("unterminated escape sequence")
+	}
+	r := p.next()
+	switch r {
+	case 'b':
+		return "\b", nil
+	case 't':
+		return "\t", nil
+	case 'n':
+		return "\n", nil
+	case 'f':
+		return "\f", nil
+	case 'r':
+		return "\r", nil
+	case '"':
+		return "\"", nil
+	case '\\':
+		return "\\", nil
+	case 'u', 'U':
+		length := 4
+		if r == 'U' {
+			length = 8
+		}
+		if p.pos+length > len(p.src) {
+			return "", fmt.Errorf("invalid unicode escape")
+		}
+		code, err := strconv.ParseUint(string(p.src[p.pos:p.pos+length]), 16, 32)
+		if err != nil {
+			return "", fmt.Errorf("invalid unicode escape")
+		}
+		p.pos += length
+		return string(rune(code)), nil
+	}
+	return "", fmt.Errorf("invalid escape sequence '\\%c'", r)
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.next()
+	var buf strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		r := p.next()
+		switch r {
+		case '"':
+			return buf.String(), nil
+		case '\\':
+			s, err := p.parseEscape()
+			if err != nil {
+				return "", err
+			}
+			buf.WriteString(s)
+		default:
+			buf.WriteRune(r)
+		}
+	}
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.next()
+	start := p.pos
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if p.next() == '\'' {
+			return string(p.src[start : p.pos-1]), nil
+		}
+	}
+}
+
+func (p *tomlParser) skipLeadingNewline() {
+	if p.hasPrefix("\r\n") {
+		p.pos++
+	}
+	if p.peek() == '\n' {
+		p.next()
+	}
+}
+
+func (p *tomlParser) parseMultilineBasicString() (string, error) {
+	p.pos += 3
+	p.skipLeadingNewline()
+	var buf strings.Builder
+	for {
+		if p.eof() {
+			return "", fmt.Errorf("unterminated multi-line string")
+		}
+		if p.hasPrefix(`"""`) && !p.hasPrefix(`""""`) {
+			p.pos += 3
+			return buf.String(), nil
+		}
+		r := p.next()
+		if r != '\\' {
+			buf.WriteRune(r)
+			continue
+		}
+		// a backslash at the end of a line trims the newline and any following whitespace
+		rest := p.pos
+		for rest < len(p.src) && (p.src[rest] == ' ' || p.src[rest] == '\t') {
+			rest++
+		}
+		if rest < len(p.src) && (p.src[rest] == '\n' || p.src[rest] == '\r') {
+			for !p.eof() && strings.ContainsRune(" \t\r\n", p.peek()) {
+				p.next()
+			}
+			continue
+		}
+		s, err := p.parseEscape()
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(s)
+	}
+}
+
+func (p *tomlParser) parseMultilineLiteralString() (string, error) {
+	p.pos += 3
+	p.skipLeadingNewline()
+	start := p.pos
+	for {
+		if p.eof() {
+			return "", fmt.Errorf("unterminated multi-line string")
+		}
+		if p.hasPrefix(`'''`) && !p.hasPrefix(`''''`) {
+			out := string(p.src[start:p.pos])
+			p.pos += 3
+			return out, nil
+		}
+		p.next()
+	}
+}
+
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	p.next()
+	out := []interface{}{}
+	for {
+		p.skipWhitespace()
+		if p.eof() {
+			return nil, fmt.Errorf("unterminated array")
+		}
+		if p.peek() == ']' {
+			p.next()
+			return out, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+		p.skipWhitespace()
+		if p.peek() == ',' {
+			p.next()
+		} else if p.peek() != ']' {
+			return nil, fmt.Errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+func (p *tomlParser) parseInlineTable() (map[string]interface{}, error) {
+	p.next()
+	out := map[string]interface{}{}
+	p.skipSpace()
+	if p.peek() == '}' {
+		p.next()
+		return out, nil
+	}
+	for {
+		if err := p.parseKeyValue(out); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.eof() {
+			return nil, fmt.Errorf("unterminated inline table")
+		}
+		switch p.next() {
+		case ',':
+			continue
+		case '}':
+			return out, nil
+		}
+		return nil, fmt.Errorf("expected ',' or '}' in inline table")
+	}
+}
+
+var (
+	tomlIntegerRegexp  = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)$`)
+	tomlPrefixedRegexp = regexp.MustCompile(`^0(x[0-9A-Fa-f](_?[0-9A-Fa-f])*|o[0-7](_?[0-7])*|b[01](_?[01])*)$`)
+	tomlFloatRegexp    = regexp.MustCompile(`^[+-]?(0|[1-9](_?[0-9])*)(\.[0-9](_?[0-9])*)?([eE][+-]?[0-9](_?[0-9])*)?$`)
+)
+
+// tomlTimeLayouts are the date and time forms allowed by TOML, the space separated forms are normalised to 'T' first.
+var tomlTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+	"15:04:05.999999999",
+}
+
+// parseScalar parses the bare values: numbers, dates and times.
+func (p *tomlParser) parseScalar() (interface{}, error) {
+	start := p.pos
+	for !p.eof() {
+		r := p.peek()
+		if r == ',' || r == ']' || r == '}' || r == '#' || r == '\n' || r == '\r' || r == '\t' {
+			break
+		}
+		// a space is only part of a value when it separates a date from a time
+		if r == ' ' {
+			if p.pos-start == 10 && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9' {
+				p.next()
+				continue
+			}
+			break
+		}
+		p.next()
+	}
+	raw := string(p.src[start:p.pos])
+	switch raw {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	case "":
+		return nil, fmt.Errorf("expected a value")
+	}
+	clean := strings.Replace(raw, "_", "", -1)
+	if tomlIntegerRegexp.MatchString(raw) {
+		i, err := strconv.ParseInt(clean, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("integer '%s' is out of range", raw)
+		}
+		return int(i), nil
+	}
+	if tomlPrefixedRegexp.MatchString(raw) {
+		i, err := strconv.ParseInt(clean, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("integer '%s' is out of range", raw)
+		}
+		return int(i), nil
+	}
+	if tomlFloatRegexp.MatchString(raw) {
+		f, err := strconv.ParseFloat(clean, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float '%s'", raw)
+		}
+		return f, nil
+	}
+	normalised := strings.Replace(strings.Replace(raw, " ", "T", 1), "t", "T", 1)
+	normalised = strings.Replace(normalised, "z", "Z", 1)
+	for _, layout := range tomlTimeLayouts {
+		if t, err := time.Parse(layout, normalised); err == nil {
+			if layout == tomlTimeLayouts[3] {
+				// a local time has no date, keep it as written
+				return raw, nil
+			}
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid value '%s'", raw)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeTOML(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  map[string]interface{}
+	}{
+		{"empty", "", map[string]interface{}{}},
+		{"comments", "# a comment\n\na = 1 # trailing\n", map[string]interface{}{"a": 1}},
+		{"bare and quoted keys", "bare-key_1 = 1\n\"quoted key\" = 2\n'literal key' = 3\n", map[string]interface{}{
+			"bare-key_1": 1, "quoted key": 2, "literal key": 3,
+		}},
+		{"dotted keys", "a.b.c = 1\na.b.d = 2\n", map[string]interface{}{
+			"a": map[string]interface{}{"b": map[string]interface{}{"c": 1, "d": 2}},
+		}},
+		{"integers", "a = +42\nb = -17\nc = 1_000\nd = 0xff\ne = 0o17\nf = 0b101\n", map[string]interface{}{
+			"a": 42, "b": -17, "c": 1000, "d": 255, "e": 15, "f": 5,
+		}},
+		{"floats", "a = 3.14\nb = -0.5\nc = 5e+22\nd = 1_000.5\n", map[string]interface{}{
+			"a": 3.14, "b": -0.5, "c": 5e+22, "d": 1000.5,
+		}},
+		{"booleans", "a = true\nb = false\n", map[string]interface{}{"a": true, "b": false}},
+		{"basic strings", `a = "tab\there \"quoted\" \u00e9"` + "\n", map[string]interface{}{"a": "tab\there \"quoted\" é"}},
+		{"literal strings", `a = 'C:\path\no escapes'` + "\n", map[string]interface{}{"a": `C:\path\no escapes`}},
+		{"multiline basic string", "a = \"\"\"\nline one\nline \\\n    two\"\"\"\n", map[string]interface{}{"a": "line one\nline two"}},
+		{"multiline literal string", "a = '''\nraw \\n\ntext'''\n", map[string]interface{}{"a": "raw \\n\ntext"}},
+		{"arrays", "a = [1, 2, 3]\nb = [\n  \"x\",\n  \"y\", # comment\n]\nc = []\n", map[string]interface{}{
+			"a": []interface{}{1, 2, 3}, "b": []interface{}{"x", "y"}, "c": []interface{}{},
+		}},
+		{"inline tables", "a = {x = 1, y.z = \"two\"}\nb = {}\n", map[string]interface{}{
+			"a": map[string]interface{}{"x": 1, "y": map[string]interface{}{"z": "two"}},
+			"b": map[string]interface{}{},
+		}},
+		{"tables", "top = 1\n[server]\nhost = \"x\"\n[server.tls]\nenabled = true\n", map[string]interface{}{
+			"top": 1,
+			"server": map[string]interface{}{
+				"host": "x",
+				"tls":  map[string]interface{}{"enabled": true},
+			},
+		}},
+		{"super table after sub table", "[a.b]\nc = 1\n[a]\nd = 2\n", map[string]interface{}{
+			"a": map[string]interface{}{"b": map[string]interface{}{"c": 1}, "d": 2},
+		}},
+		{"arrays of tables", "[[items]]\nname = \"a\"\n[items.meta]\nx = 1\n[[items]]\nname = \"b\"\n", map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"name": "a", "meta": map[string]interface{}{"x": 1}},
+				map[string]interface{}{"name": "b"},
+			},
+		}},
+		{"dates and times", "a = 1979-05-27T07:32:00Z\nb = 1979-05-27\nc = 07:32:00\nd = 1979-05-27 07:32:00Z\n", map[string]interface{}{
+			"a": time.Date(1979, 5, 27, 7, 32, 0, 0, time.UTC),
+			"b": time.Date(1979, 5, 27, 0, 0, 0, 0, time.UTC),
+			"c": "07:32:00",
+			"d": time.Date(1979, 5, 27, 7, 32, 0, 0, time.UTC),
+		}},
+		{"crlf line endings", "a = 1\r\nb = 2\r\n", map[string]interface{}{"a": 1, "b": 2}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := decodeTOML([]byte(c.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got %#v, want %#v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDecodeTOMLSpecialFloats(t *testing.T) {
+	got, err := decodeTOML([]byte("a = inf\nb = -inf\nc = nan\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !math.IsInf(got["a"].(float64), 1) || !math.IsInf(got["b"].(float64), -1) || !math.IsNaN(got["c"].(float64)) {
+		t.Errorf("got %#v", got)
+	}
+}
+
+func TestDecodeTOMLErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"duplicate key", "a = 1\na = 2\n", "line 2: key 'a' is defined more than once"},
+		{"duplicate table", "[a]\n[a]\n", "table 'a' is defined more than once"},
+		{"value redefined as table", "a = 1\n[a]\n", "key 'a' is already defined as a value"},
+		{"extend inline table with dotted key", "a = {x = 1}\na.y = 2\n", "key 'a' is an inline table and can not be extended"},
+		{"extend inline table with header", "a = {x = 1}\n[a.b]\n", "key 'a' is an inline table and can not be extended"},
+		{"extend nested inline table", "a = {b = {x = 1}}\n[a]\n", "key 'a' is an inline table and can not be extended"},
+		{"extend static array", "a = [{x = 1}]\n[[a]]\n", "key 'a' is a static array and can not be extended"},
+		{"extend empty static array", "a = []\n[[a]]\n", "key 'a' is a static array and can not be extended"},
+		{"table in static array", "a = [{x = 1}]\n[a.b]\n", "key 'a' is not a table"},
+		{"missing value", "a =\n", "expected a value"},
+		{"missing equals", "a 1\n", "expected '=' after key 'a'"},
+		{"two values on a line", "a = 1 b = 2\n", "unexpected 'b' at the end of the line"},
+		{"unterminated string", "a = \"x\n", "line 1"},
+		{"unterminated array", "a = [1,\n", "unterminated array"},
+		{"unterminated inline table", "a = {x = 1", "unterminated inline table"},
+		{"newline in inline table", "a = {x = 1,\ny = 2}\n", "line 1"},
+		{"leading zero", "a = 012\n", "invalid value '012'"},
+		{"integer out of range", "a = 9223372036854775808\n", "integer '9223372036854775808' is out of range"},
+		{"unclosed header", "[a\n", "expected ']' to close the table header"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := decodeTOML([]byte(c.input))
+			if err == nil {
+				t.Fatalf("expected an error containing %q", c.want)
+			}
+			if !strings.Contains(err.Error(), c.want) {
+				t.Errorf("got error %q, want it to contain %q", err, c.want)
+			}
+		})
+	}
+}
+
+func TestEncodeTOML(t *testing.T) {
+	cases := []struct {
+		name  string
+		input interface{}
+		want  string
+	}{
+		{"scalars", map[string]interface{}{"b": true, "f": 1.0, "i": 2, "s": "x\"y"}, "b = true\nf = 1.0\ni = 2\ns = \"x\\\"y\"\n"},
+		{"quoted keys", map[string]interface{}{"a b": 1}, "\"a b\" = 1\n"},
+		{"tables after values", map[string]interface{}{"t": map[string]interface{}{"x": 1}, "a": []interface{}{1, "two"}}, "a = [1, \"two\"]\n\n[t]\nx = 1\n"},
+		{"arrays of tables", map[string]interface{}{"items": []interface{}{map[string]interface{}{"n": 1}, map[string]interface{}{"n": 2}}}, "[[items]]\nn = 1\n\n[[items]]\nn = 2\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := encodeTOML(c.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != c.want {
+				t.Errorf("got %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestEncodeTOMLRoundTrip(t *testing.T) {
+	in := map[string]interface{}{
+		"name":  "spiro",
+		"count": 3,
+		"ratio": 0.5,
+		"tags":  []interface{}{"a", "b"},
+		"owner": map[string]interface{}{"name": "x", "inline": map[string]interface{}{}},
+		"items": []interface{}{map[string]interface{}{"n": 1}, map[string]interface{}{"n": 2}},
+	}
+	encoded, err := encodeTOML(in)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	decoded, err := decodeTOML([]byte(encoded))
+	if err != nil {
+		t.Fatalf("could not decode %q: %s", encoded, err)
+	}
+	if !reflect.DeepEqual(decoded, in) {
+		t.Errorf("got %#v, want %#v", decoded, in)
+	}
+}
+
+func TestEncodeTOMLErrors(t *testing.T) {
+	if _, err := encodeTOML([]interface{}{1}); err == nil {
+		t.Error("expected an error for a top level list")
+	}
+	if _, err := encodeTOML(map[string]interface{}{"a": nil}); err == nil {
+		t.Error("expected an error for a null value")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+// SpecialVarsKey is the spec key holding derived variables. They are added to, and override, the vars declared in the
+// template manifest.
+const SpecialVarsKey = "_spiro_vars_"
+
+var varNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// derivedVar is a variable computed from the spec. String values are template expressions, other values are constants.
+type derivedVar struct {
+	name  string
+	value interface{}
+}
+
+// collectVars merges the vars of the manifest with the vars in the spec. Spec vars replace manifest vars of the same
+// name and are otherwise added in alphabetical order since maps in the spec are unordered.
+func collectVars(manifest *templateManifest, spec map[string]interface{}) ([]derivedVar, error) {
+	var vars []derivedVar
+	positions := make(map[string]int)
+	add := func(name string, value interface{}) {
+		if i, ok := positions[name]; ok {
+			vars[i].value = value
+			return
+		}
+		positions[name] = len(vars)
+		vars = append(vars, derivedVar{name: name, value: value})
+	}
+	if manifest != nil {
+		for _, item := range manifest.Vars {
+			add(fmt.Sprint(item.Key), item.Value)
+		}
+	}
+	if raw, ok := spec[SpecialVarsKey]; ok && raw != nil {
+		specVars, err := toSpecMap(raw)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' must be a map of names to template expressions", SpecialVarsKey)
+		}
+		names := make([]string, 0, len(specVars))
+		for name := range specVars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(name, specVars[name])
+		}
+	}
+	return vars, nil
+}
+
+// evaluateVars evaluates the derived vars against the spec and adds each result to it. A var may refer to other vars,
+// so they are evaluated in dependency order and a cycle is reported as an error.
+func evaluateVars(tf *templatefactory.TemplateFactory, spec *map[string]interface{}, vars []derivedVar) error {
+	byName := make(map[string]derivedVar, len(vars))
+	for _, v := range vars {
+		if !varNameRegexp.MatchString(v.name) {
+			return fmt.Errorf("Invalid var name '%s': names must start with a letter or underscore and contain only letters, digits and underscores", v.name)
+		}
+		if _, exists := (*spec)[v.name]; exists {
+			return fmt.Errorf("Var '%s' conflicts with a key of the same name in the spec", v.name)
+		}
+		byName[v.name] = v
+	}
+
+	startDelim, endDelim := tf.Delimiters()
+	dependencies := make(map[string][]string, len(vars))
+	for _, v := range vars {
+		expression, ok := v.value.(string)
+		if !ok {
+			continue
+		}
+		refs, err := findTemplateReferences(startDelim+" "+expression+" "+endDelim, startDelim, endDelim)
+		if err != nil {
+			return fmt.Errorf("Error while parsing var '%s': %s", v.name, err)
+		}
+		for field := range refs.fields {
+			if _, isVar := byName[field]; isVar {
+				dependencies[v.name] = append(dependencies[v.name], field)
+			}
+		}
+		sort.Strings(dependencies[v.name])
+	}
+
+	const (
+		pending = iota
+		visiting
+		done
+	)
+	state := make(map[string]int, len(vars))
+	var stack []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			for i, item := range stack {
+				if item == name {
+					return fmt.Errorf("Vars refer to each other in a cycle: %s -> %s", strings.Join(stack[i:], " -> "), name)
+				}
+			}
+		}
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, dependency := range dependencies[name] {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+
+		v := byName[name]
+		expression, ok := v.value.(string)
+		if !ok {
+			(*spec)[name] = v.value
+			return nil
+		}
+		value, err := tf.Evaluate(templatefactory.Source{Path: SpecialVarsKey + "." + name, Kind: "var"}, expression)
+		if err != nil {
+			var failure *templateFailure
+			if errors.As(err, &failure) {
+				return fmt.Errorf("Var '%s' failed: %s", name, failure.message)
+			}
+			return fmt.Errorf("Error while evaluating var '%s': %w", name, err)
+		}
+		(*spec)[name] = value
+		return nil
+	}
+	for _, v := range vars {
+		if err := visit(v.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
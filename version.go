@@ -0,0 +1,383 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SpecialMinVersionKey is the spec key that declares the lowest version of spiro that can render the template.
+const SpecialMinVersionKey = "_spiro_min_version_"
+
+// SpecialVersionKey is the spec key that declares a semver constraint expression that the running version of spiro
+// must satisfy, for example ">=1.8 <2", "~1.9" or "^1.8.0 || >=3".
+const SpecialVersionKey = "_spiro_version_"
+
+// semver is a parsed semantic version. Only the parts used for precedence are kept, build metadata is discarded.
+type semver struct {
+	major, minor, patch uint64
+	pre                 []string
+}
+
+var semverRegexp = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// parseSemver parses a full or partial version string. Missing minor and patch numbers default to 0 so "1.2" is the
+// same as "1.2.0".
+func parseSemver(in string) (semver, error) {
+	var v semver
+	match := semverRegexp.FindStringSubmatch(strings.TrimSpace(in))
+	if match == nil {
+		return v, fmt.Errorf("'%s' is not a valid semantic version", in)
+	}
+	v.major, _ = strconv.ParseUint(match[1], 10, 64)
+	if match[2] != "" {
+		v.minor, _ = strconv.ParseUint(match[2], 10, 64)
+	}
+	if match[3] != "" {
+		v.patch, _ = strconv.ParseUint(match[3], 10, 64)
+	}
+	if match[4] != "" {
+		v.pre = strings.Split(match[4], ".")
+	}
+	return v, nil
+}
+
+func (v semver) String() string {
+	out := fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	if len(v.pre) > 0 {
+		out += "-" + strings.Join(v.pre, ".")
+	}
+	return out
+}
+
+func compareUint(a, b uint64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// compareSemver returns -1, 0 or 1 following the semver 2.0 precedence rules. A prerelease version has a lower
+// precedence than the associated normal version.
+func compareSemver(a, b semver) int {
+	if c := compareUint(a.major, b.major); c != 0 {
+		return c
+	}
+	if c := compareUint(a.minor, b.minor); c != 0 {
+		return c
+	}
+	if c := compareUint(a.patch, b.patch); c != 0 {
+		return c
+	}
+	if len(a.pre) == 0 || len(b.pre) == 0 {
+		// no prerelease is greater than any prerelease
+		return compareUint(uint64(len(b.pre)), uint64(len(a.pre)))
+	}
+	for i := 0; i < len(a.pre) && i < len(b.pre); i++ {
+		an, aerr := strconv.ParseUint(a.pre[i], 10, 64)
+		bn, berr := strconv.ParseUint(b.pre[i], 10, 64)
+		switch {
+		case aerr == nil && berr == nil:
+			if c := compareUint(an, bn); c != 0 {
+				return c
+			}
+		case aerr == nil:
+			// numeric identifiers have lower precedence than alphanumeric ones
+			return -1
+		case berr == nil:
+			return 1
+		default:
+			if c := strings.Compare(a.pre[i], b.pre[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareUint(uint64(len(a.pre)), uint64(len(b.pre)))
+}
+
+// comparator is a single primitive comparison like ">=1.2.0".
+type comparator struct {
+	op string
+	v  semver
+}
+
+func (c comparator) matches(v semver) bool {
+	cmp := compareSemver(v, c.v)
+	switch c.op {
+	case "<":
+		// "<2" should not admit the prereleases of 2.0.0 since they are likely to contain the breaking changes
+		if len(v.pre) > 0 && len(c.v.pre) == 0 && v.major == c.v.major && v.minor == c.v.minor && v.patch == c.v.patch {
+			return false
+		}
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "!=":
+		return cmp != 0
+	}
+	return cmp == 0
+}
+
+// versionConstraint is a set of alternatives (joined by "||") where each alternative is a list of comparators that must
+// all match.
+type versionConstraint struct {
+	raw  string
+	sets [][]comparator
+}
+
+var comparatorRegexp = regexp.MustCompile(`^(<=|>=|!=|==|<|>|=|~>|~|\^)?\s*v?([0-9xX*]+(?:\.[0-9xX*]+)?(?:\.[0-9xX*]+)?(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?)$`)
+
+// parseVersionConstraint parses a constraint expression. The supported syntax follows the usual npm/cargo conventions:
+//
+//	>=1.8 <2       both comparators must match (commas may also be used as separators)
+//	1.8 || 2.1     either alternative must match
+//	1.2 - 1.4      inclusive hyphen range
+//	~1.9           >=1.9.0 <1.10.0
+//	^1.8           >=1.8.0 <2.0.0
+//	1.x, 1.*       >=1.0.0 <2.0.0
+//
+// Prerelease versions are ordered by normal semver precedence (1.9.0-rc.1 satisfies >=1.8 but not >=1.9) except that
+// an exclusive upper bound never admits the prereleases of that bound, so 2.0.0-rc.1 does not satisfy <2.
+func parseVersionConstraint(in string) (*versionConstraint, error) {
+	out := &versionConstraint{raw: strings.TrimSpace(in)}
+	if out.raw == "" {
+		return nil, fmt.Errorf("Version constraint is empty")
+	}
+	for _, alternative := range strings.Split(out.raw, "||") {
+		fields := strings.Fields(strings.Replace(alternative, ",", " ", -1))
+		var set []comparator
+		for i := 0; i < len(fields); i++ {
+			field := fields[i]
+			// allow the operator to be separated from the version: ">= 1.2"
+			if strings.Trim(field, "<>=!~^") == "" && i+1 < len(fields) {
+				i++
+				field += fields[i]
+			}
+			// hyphen ranges: "1.2 - 1.4"
+			if i+2 < len(fields) && fields[i+1] == "-" {
+				lower, err := expandComparator(">=" + field)
+				if err != nil {
+					return nil, err
+				}
+				upper, err := expandComparator("<=" + fields[i+2])
+				if err != nil {
+					return nil, err
+				}
+				set = append(set, lower...)
+				set = append(set, upper...)
+				i += 2
+				continue
+			}
+			comparators, err := expandComparator(field)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, comparators...)
+		}
+		if len(set) == 0 {
+			return nil, fmt.Errorf("Version constraint '%s' contains an empty alternative", out.raw)
+		}
+		out.sets = append(out.sets, set)
+	}
+	return out, nil
+}
+
+// expandComparator converts a single comparator with an optional wildcard or partial version into primitive
+// comparators.
+func expandComparator(in string) ([]comparator, error) {
+	match := comparatorRegexp.FindStringSubmatch(in)
+	if match == nil {
+		return nil, fmt.Errorf("'%s' is not a valid version comparator", in)
+	}
+	op := match[1]
+	raw := match[2]
+
+	// work out how many of the version parts were actually given
+	core := raw
+	if i := strings.IndexAny(core, "-+"); i >= 0 {
+		core = core[:i]
+	}
+	given := 0
+	for _, p := range strings.Split(core, ".") {
+		if p == "x" || p == "X" || p == "*" {
+			break
+		}
+		given++
+	}
+	if given == 0 {
+		// "*" or "x" matches anything when used as a lower bound, and nothing when used as an upper bound
+		switch op {
+		case "<", "!=":
+			return []comparator{{op: "<", v: semver{}}}, nil
+		default:
+			return []comparator{{op: ">=", v: semver{}}}, nil
+		}
+	}
+	parts := strings.Split(core, ".")[:given]
+	v, err := parseSemver(strings.Join(parts, ".") + strings.TrimPrefix(raw, core))
+	if err != nil {
+		return nil, err
+	}
+
+	// next is the first version above the given partial version: 1.2 -> 1.3.0, 1 -> 2.0.0
+	next := semver{major: v.major + 1}
+	if given == 2 {
+		next = semver{major: v.major, minor: v.minor + 1}
+	} else if given == 3 {
+		next = semver{major: v.major, minor: v.minor, patch: v.patch + 1}
+	}
+
+	switch op {
+	case "", "=", "==":
+		if given == 3 {
+			return []comparator{{op: "=", v: v}}, nil
+		}
+		return []comparator{{op: ">=", v: v}, {op: "<", v: next}}, nil
+	case "!=":
+		return []comparator{{op: "!=", v: v}}, nil
+	case ">":
+		if given == 3 {
+			return []comparator{{op: ">", v: v}}, nil
+		}
+		return []comparator{{op: ">=", v: next}}, nil
+	case ">=":
+		return []comparator{{op: ">=", v: v}}, nil
+	case "<":
+		return []comparator{{op: "<", v: v}}, nil
+	case "<=":
+		if given == 3 {
+			return []comparator{{op: "<=", v: v}}, nil
+		}
+		return []comparator{{op: "<", v: next}}, nil
+	case "~", "~>":
+		upper := semver{major: v.major, minor: v.minor + 1}
+		if given == 1 {
+			upper = semver{major: v.major + 1}
+		}
+		return []comparator{{op: ">=", v: v}, {op: "<", v: upper}}, nil
+	case "^":
+		var upper semver
+		switch {
+		case v.major > 0 || given == 1:
+			upper = semver{major: v.major + 1}
+		case v.minor > 0 || given == 2:
+			upper = semver{minor: v.minor + 1}
+		default:
+			upper = semver{patch: v.patch + 1}
+		}
+		return []comparator{{op: ">=", v: v}, {op: "<", v: upper}}, nil
+	}
+	return nil, fmt.Errorf("Unsupported version operator '%s' in '%s'", op, in)
+}
+
+// Check returns true if the given version satisfies the constraint.
+func (c *versionConstraint) Check(v semver) bool {
+	for _, set := range c.sets {
+		if setMatches(set, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func setMatches(set []comparator, v semver) bool {
+	for _, c := range set {
+		if !c.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// gitDescribeSuffixRegexp matches the suffix that `git describe` appends to builds made after a tag.
+var gitDescribeSuffixRegexp = regexp.MustCompile(`-\d+-g[0-9a-f]+(-dirty)?$|-dirty$`)
+
+// currentSemver extracts the semantic version from the Version string set at build time. Official builds look like
+// "v1.8.0 (commit abc1234 @ 2018-03-18)".
+func currentSemver() (semver, bool) {
+	fields := strings.Fields(Version)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "v") {
+		return semver{}, false
+	}
+	v, err := parseSemver(gitDescribeSuffixRegexp.ReplaceAllString(fields[0], ""))
+	if err != nil {
+		return semver{}, false
+	}
+	return v, true
+}
+
+// checkVersionIfNecessary compares the running version against the constraints declared in the spec file. If the
+// running version does not satisfy them, an error naming the requirement is returned.
+func checkVersionIfNecessary(spec *map[string]interface{}) error {
+	var constraints []string
+	var keys []string
+	for _, key := range []string{SpecialMinVersionKey, SpecialVersionKey} {
+		raw, ok := (*spec)[key]
+		if !ok || raw == nil {
+			continue
+		}
+		var constraint string
+		switch value := raw.(type) {
+		case string:
+			constraint = value
+		case int, float64:
+			// older specs use unquoted versions, which keep working as long as they decode to the same number
+			constraint = fmt.Sprint(value)
+			logger.Warn("The %s %s is a number, quote it like %s: \"%s\" since a version like 1.10 is read as 1.1", key, constraint, key, constraint)
+		default:
+			return fmt.Errorf("Could not parse version requirement in %s: the version must be a string", key)
+		}
+		if key == SpecialMinVersionKey {
+			constraint = ">=" + constraint
+		}
+		constraints = append(constraints, constraint)
+		keys = append(keys, key)
+	}
+	if len(constraints) == 0 {
+		return nil
+	}
+	return checkVersionConstraints(constraints, keys)
+}
+
+// checkManifestVersion compares the running version against the constraints declared in the manifest of a template
+// directory.
+func checkManifestVersion(manifest *templateManifest, root string) error {
+	var constraints []string
+	var sources []string
+	file := filepath.Join(root, manifestFileName)
+	if manifest.MinVersion != "" {
+		constraints = append(constraints, ">="+manifest.MinVersion)
+		sources = append(sources, fmt.Sprintf("min_version of '%s'", file))
+	}
+	if manifest.Version != "" {
+		constraints = append(constraints, manifest.Version)
+		sources = append(sources, fmt.Sprintf("version of '%s'", file))
+	}
+	return checkVersionConstraints(constraints, sources)
+}
+
+func checkVersionConstraints(constraints []string, sources []string) error {
+	current, official := currentSemver()
+	for i, raw := range constraints {
+		constraint, err := parseVersionConstraint(raw)
+		if err != nil {
+			return fmt.Errorf("Could not parse version requirement in %s: %s", sources[i], err)
+		}
+		if !official {
+			logger.Warn("This is an unofficial build of spiro (%s), skipping the template's version requirement '%s'", Version, constraint.raw)
+			continue
+		}
+		if !constraint.Check(current) {
+			return fmt.Errorf("This template requires spiro version '%s' (from %s) but you are running %s", constraint.raw, sources[i], Version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustSemver(t *testing.T, in string) semver {
+	v, err := parseSemver(in)
+	if err != nil {
+		t.Fatalf("could not parse %q: %s", in, err)
+	}
+	return v
+}
+
+func TestCompareSemver(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"1.2", "1.2.0", 0},
+		{"v1", "1.0.0", 0},
+		{"1.2.3+build.1", "1.2.3", 0},
+		{"1.2.3", "1.2.4", -1},
+		{"1.3.0", "1.2.9", 1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta", "1.0.0-alpha", 1},
+		{"1.0.0-rc.1", "0.9.0", 1},
+	}
+	for _, c := range cases {
+		if got := compareSemver(mustSemver(t, c.a), mustSemver(t, c.b)); got != c.want {
+			t.Errorf("compare %s to %s: got %d, want %d", c.a, c.b, got, c.want)
+		}
+		if got := compareSemver(mustSemver(t, c.b), mustSemver(t, c.a)); got != -c.want {
+			t.Errorf("compare %s to %s: got %d, want %d", c.b, c.a, got, -c.want)
+		}
+	}
+}
+
+func TestParseSemverErrors(t *testing.T) {
+	for _, in := range []string{"", "x", "1.2.3.4", "1.x", "1.2.3-", "-1"} {
+		if _, err := parseSemver(in); err == nil {
+			t.Errorf("expected an error for %q", in)
+		}
+	}
+}
+
+func TestExpandComparator(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"1.2.3", "=1.2.3"},
+		{"=1.2", ">=1.2.0 <1.3.0"},
+		{"==1", ">=1.0.0 <2.0.0"},
+		{"1.x", ">=1.0.0 <2.0.0"},
+		{"1.2.*", ">=1.2.0 <1.3.0"},
+		{"*", ">=0.0.0"},
+		{"<x", "<0.0.0"},
+		{"!=1.2.3", "!=1.2.3"},
+		{">1.2.3", ">1.2.3"},
+		{">1.2", ">=1.3.0"},
+		{">=1.2", ">=1.2.0"},
+		{"<2", "<2.0.0"},
+		{"<=1.2.3", "<=1.2.3"},
+		{"<=1.2", "<1.3.0"},
+		{"v1.2.3", "=1.2.3"},
+		{">=1.9.0-rc.1", ">=1.9.0-rc.1"},
+		{"~1.9", ">=1.9.0 <1.10.0"},
+		{"~1.9.2", ">=1.9.2 <1.10.0"},
+		{"~1", ">=1.0.0 <2.0.0"},
+		{"~>1.9", ">=1.9.0 <1.10.0"},
+		{"^1.8", ">=1.8.0 <2.0.0"},
+		{"^1.8.3", ">=1.8.3 <2.0.0"},
+		{"^0.2.3", ">=0.2.3 <0.3.0"},
+		{"^0.0.3", ">=0.0.3 <0.0.4"},
+		{"^0.0", ">=0.0.0 <0.1.0"},
+		{"^0", ">=0.0.0 <1.0.0"},
+	}
+	for _, c := range cases {
+		comparators, err := expandComparator(c.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.input, err)
+			continue
+		}
+		var got []string
+		for _, comparator := range comparators {
+			got = append(got, comparator.op+comparator.v.String())
+		}
+		if strings.Join(got, " ") != c.want {
+			t.Errorf("%s: got %s, want %s", c.input, strings.Join(got, " "), c.want)
+		}
+	}
+}
+
+func TestExpandComparatorErrors(t *testing.T) {
+	for _, in := range []string{"", ">>1", "1.2.3.4", "abc", "=>1"} {
+		if _, err := expandComparator(in); err == nil {
+			t.Errorf("expected an error for %q", in)
+		}
+	}
+}
+
+func TestParseVersionConstraint(t *testing.T) {
+	cases := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+	}{
+		{">=1.8 <2", []string{"1.8.0", "1.9.5", "1.99.0"}, []string{"1.7.9", "2.0.0", "2.0.0-rc.1"}},
+		{">=1.8, <2", []string{"1.8.0"}, []string{"2.0.0"}},
+		{">= 1.8 < 2", []string{"1.8.0"}, []string{"2.0.0"}},
+		{"~1.8 || >=2.1", []string{"1.8.4", "2.1.0", "3.0.0"}, []string{"1.9.0", "2.0.5"}},
+		{"1.2 - 1.4", []string{"1.2.0", "1.4.9"}, []string{"1.1.9", "1.5.0"}},
+		{"1.2.3 - 1.4.5", []string{"1.2.3", "1.4.5"}, []string{"1.2.2", "1.4.6"}},
+		{">=1.8", []string{"1.9.0-rc.1"}, []string{"1.8.0-rc.1"}},
+		{">=1.9", []string{"1.9.0"}, []string{"1.9.0-rc.1"}},
+		{"<=2", []string{"2.5.0"}, []string{"3.0.0-rc.1", "3.0.0"}},
+		{"<2.0.0-rc.2", []string{"2.0.0-rc.1"}, []string{"2.0.0-rc.2", "2.0.0"}},
+		{"^1.8", []string{"1.8.0", "1.99.0"}, []string{"2.0.0", "1.7.0"}},
+		{"1.x", []string{"1.0.0", "1.5.5"}, []string{"0.9.0", "2.0.0"}},
+		{"!=1.5.0", []string{"1.5.1"}, []string{"1.5.0"}},
+		{"*", []string{"0.0.0", "9.9.9"}, nil},
+	}
+	for _, c := range cases {
+		constraint, err := parseVersionConstraint(c.constraint)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.constraint, err)
+			continue
+		}
+		for _, v := range c.matches {
+			if !constraint.Check(mustSemver(t, v)) {
+				t.Errorf("%s: expected %s to match", c.constraint, v)
+			}
+		}
+		for _, v := range c.rejects {
+			if constraint.Check(mustSemver(t, v)) {
+				t.Errorf("%s: expected %s not to match", c.constraint, v)
+			}
+		}
+	}
+}
+
+func TestParseVersionConstraintSets(t *testing.T) {
+	constraint, err := parseVersionConstraint(" 1.2 - 1.4 || ^2 ")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	want := [][]comparator{
+		{{op: ">=", v: semver{major: 1, minor: 2}}, {op: "<", v: semver{major: 1, minor: 5}}},
+		{{op: ">=", v: semver{major: 2}}, {op: "<", v: semver{major: 3}}},
+	}
+	if constraint.raw != "1.2 - 1.4 || ^2" || !reflect.DeepEqual(constraint.sets, want) {
+		t.Errorf("got %q %#v, want %#v", constraint.raw, constraint.sets, want)
+	}
+}
+
+func TestParseVersionConstraintErrors(t *testing.T) {
+	cases := []struct {
+		constraint string
+		want       string
+	}{
+		{"", "Version constraint is empty"},
+		{"  ", "Version constraint is empty"},
+		{"1.2 ||", "contains an empty alternative"},
+		{"|| 1.2", "contains an empty alternative"},
+		{">=1.2 <abc", "'<abc' is not a valid version comparator"},
+		{"1.2 - x.y.z", "is not a valid version comparator"},
+	}
+	for _, c := range cases {
+		_, err := parseVersionConstraint(c.constraint)
+		if err == nil {
+			t.Errorf("%q: expected an error containing %q", c.constraint, c.want)
+		} else if !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%q: got error %q, want it to contain %q", c.constraint, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/astromechza/spiro/templatefactory"
+)
+
+// watchPollInterval is how often watch mode checks the template tree and spec file for changes.
+const watchPollInterval = 500 * time.Millisecond
+
+// watch renders the template and then polls the template tree, the local base templates it extends and the spec file,
+// rendering again whenever any of them changes. Errors are reported without stopping the watcher. It returns once the
+// process is interrupted.
+func watch(specFile, specFormat, outputDirectory string, settings *renderSettings) error {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	logger.Watch("Watching '%s' and '%s' for changes, press Ctrl+C to stop", settings.inputTemplate, specFile)
+	state := newRenderState()
+	lastStamp, lastError := "", ""
+	for {
+		stamp, err := watchStamp(settings, specFile)
+		if err != nil {
+			// only report a problem once rather than on every poll
+			if err.Error() != lastError {
+				logger.Error(err)
+				lastError = err.Error()
+			}
+			lastStamp = ""
+		} else if stamp != lastStamp {
+			lastStamp, lastError = stamp, ""
+			if state, err = renderWatched(specFile, specFormat, outputDirectory, settings, state); err != nil {
+				logger.Error(err)
+			}
+			logger.Watch("Waiting for changes")
+		}
+
+		select {
+		case <-interrupts:
+			return nil
+		case <-time.After(watchPollInterval):
+		}
+	}
+}
+
+// watchStamp summarises the state of the template tree, the local base templates of the last render and the spec file.
+// Bases fetched from git, remote or archive sources are not watched since they are only fetched once.
+func watchStamp(settings *renderSettings, specFile string) (string, error) {
+	var stamps []string
+	for _, root := range localRoots(settings.inputTemplate, settings.layers) {
+		stamp, err := stampTree(root)
+		if err != nil {
+			if root == settings.inputTemplate {
+				return "", fmt.Errorf("Input template '%s' cannot be read! (%s)", root, err)
+			}
+			return "", fmt.Errorf("Base template '%s' cannot be read! (%s)", root, err)
+		}
+		stamps = append(stamps, stamp)
+	}
+	info, err := os.Stat(specFile)
+	if err != nil {
+		return "", fmt.Errorf("Spec file '%s' cannot be read! (%s)", specFile, err)
+	}
+	return fmt.Sprintf("%s:%d:%d", strings.Join(stamps, ":"), info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// renderWatched reads the spec file and template manifests again and renders incrementally on top of the previous state.
+// The layers are kept in the settings so that the next stamp covers any base templates that were added.
+func renderWatched(specFile, specFormat, outputDirectory string, settings *renderSettings, previous *renderState) (*renderState, error) {
+	specContents, err := readSpecRaw(specFile)
+	if err != nil {
+		return previous, err
+	}
+	spec, err := decodeSpec(specContents, specFormat)
+	if err != nil {
+		return previous, err
+	}
+	if info, err := os.Stat(settings.inputTemplate); err == nil && info.IsDir() {
+		// the bases were fetched by the first load, so there is nothing to clean up here
+		if _, err := settings.loadLayers(); err != nil {
+			return previous, err
+		}
+	}
+	return renderSpec(spec, settings, func(*templatefactory.TemplateFactory) (string, error) {
+		return outputDirectory, nil
+	}, previous)
+}